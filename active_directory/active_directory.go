@@ -1,27 +1,42 @@
 package active_directory
 
 import (
+	"fmt"
+	"math"
+	"net"
+	"strconv"
 	"strings"
 	"time"
 )
 
 type Ad struct {
-	Name                 string    `ldap:"name"`
-	DistinguishedName    string    `ldap:"distinguishedName"`
-	DnsName              string    `ldap:"dNSHostName"`
-	Created              time.Time `ldap:"whenCreated"`
-	LastLogon            time.Time `ldap:"lastLogon"`
-	LastPassword         time.Time `ldap:"pwdLastSet"`
-	Description          []string  `ldap:"description"`
-	Location             string    `ldap:"location"`
-	ManagedBy            string    `ldap:"managedBy"`   // This is returned by the computer object and used to query the related user
-	ManagedByCn          string    `ldap:"cn"`          // This is obtained by a second query for the managedBy user
-	ManagedByGid         string    `ldap:"siemens-gid"` // This is obtained by a second query for the managedBy user
-	ManagedByDepartment  string    `ldap:"department"`  // This is obtained by a second query for the managedBy user
-	Os                   string    `ldap:"operatingSystem"`
-	OsVersion            string    `ldap:"operatingSystemVersion"`
-	ServicePrincipalName []string  `ldap:"servicePrincipalName"`
-	CriticalObject       bool      `ldap:"isCriticalSystemObject"`
+	Name                 string               `ldap:"name"`
+	DistinguishedName    string               `ldap:"distinguishedName"`
+	ObjectSid            Sid                  `ldap:"objectSid"`  // Stable identifier, changes only when moved to another domain
+	ObjectGuid           Guid                 `ldap:"objectGUID"` // Stable identifier, never changes
+	DnsName              string               `ldap:"dNSHostName"`
+	Addresses            []net.IP             // Addresses DnsName resolves to, only collected if resolving is enabled
+	AddressError         string               // Error resolving DnsName, if any
+	Created              time.Time            `ldap:"whenCreated"`
+	LastLogon            time.Time            `ldap:"lastLogon"`          // Not replicated, only the value of the queried DC, unless AccurateLastLogon is set
+	LastLogonTimestamp   time.Time            `ldap:"lastLogonTimestamp"` // Replicated, but only updated if older than 9-14 days
+	LastLogonPerDc       map[string]time.Time // lastLogon by DC host name, only collected if AccurateLastLogon is set
+	LastPassword         time.Time            `ldap:"pwdLastSet"`
+	Description          []string             `ldap:"description"`
+	Location             string               `ldap:"location"`
+	ManagedBy            string               `ldap:"managedBy"`    // This is returned by the computer object and used to query the related user
+	ManagedByCn          string               `ldap:"cn,managedBy"` // This is obtained by a second query for the managedBy user
+	ManagedByEmployeeId  string               // This is obtained by a second query for the managedBy user, attribute as per AttributeProfile
+	ManagedByCostCenter  string               // This is obtained by a second query for the managedBy user, attribute as per AttributeProfile
+	ManagedByDepartment  string               `ldap:"department,managedBy"` // This is obtained by a second query for the managedBy user
+	Owner                *Owner               // This is obtained by a second query for the managedBy user, group or contact
+	Os                   string               `ldap:"operatingSystem"`
+	OsVersion            string               `ldap:"operatingSystemVersion"`
+	ServicePrincipalName []string             `ldap:"servicePrincipalName"`
+	CriticalObject       bool                 `ldap:"isCriticalSystemObject"`
+	UserAccountControl   UserAccountControl   `ldap:"userAccountControl"`
+	UserAccountComputed  UserAccountControl   `ldap:"msDS-User-Account-Control-Computed"` // Flags computed by the DC, e.g. LOCKOUT and PASSWORD_EXPIRED
+	Extra                map[string][]string  // Additional attributes as configured by the AttributeProfile
 }
 
 // fqdnToDn transforms a fully qualified domain name (e.g. sub.domain.tld) to a distinguished name
@@ -32,91 +47,149 @@ func fqdnToDn(fqdn string) string {
 	return baseDn
 }
 
-// parseDn
-func parseDn(dn string) (string, string, string) {
+// integer8UnixEpoch is the Unix epoch as Integer8 value, i.e. in 100 nanosecond intervals since 1601-01-01 UTC
+const integer8UnixEpoch = 116444736000000000
 
-	// Split distinguished name into its segments
-	dnSplits := dnSplit(dn) // cn=name,ou=department,dc=sub,dc=domain,dc=tld => ["cn=name", "ou=department", "dc=sub", "dc=domain", "dc=tld"]
+// integer8Never is the Integer8 value used by Active Directory for timestamps that never occur (e.g. accountExpires)
+const integer8Never = math.MaxInt64
 
-	// Extract domain segments with stripped "dc=" prefixes
-	var domainSegments []string
-	for _, element := range dnSplits {
-		element = strings.ToLower(element)
-		element = strings.Trim(element, "\n")
-		if strings.HasPrefix(element, "dc=") {
-			element = strings.Replace(element, "dc=", "", -1) // Strip dc= prefixes
-			domainSegments = append(domainSegments, element)
-		}
+// Integer8ToTime converts an Integer8 timestamp (100 nanosecond intervals since 1601-01-01 UTC, e.g. lastLogon)
+// into a time. The values 0 (not set) and 0x7FFFFFFFFFFFFFFF (never) are returned as zero time, which can be
+// checked via IsZero().
+func Integer8ToTime(val int64) time.Time {
+
+	// Map values meaning "not set" or "never"
+	if val <= 0 || val == integer8Never {
+		return time.Time{}
 	}
 
-	// Prepare target domain and target DN
-	newLdapAddress := strings.Join(domainSegments, ".")
-	newSearchCn := dnSplits[0][3:len(dnSplits[0])]
-	newBaseDn := "dc=" + strings.Join(domainSegments, ",dc=")
+	// Translate to Unix time, split into seconds and remaining 100 nanosecond intervals to avoid overflows
+	val -= integer8UnixEpoch
+	timestamp := time.Unix(val/10000000, (val%10000000)*100).UTC()
 
-	// Return values
-	return newLdapAddress, newSearchCn, newBaseDn
+	// Return timestamp
+	return timestamp
 }
 
-// dnSplit splits a distinguished name (e.g. cn=host,dc=sub,dc=domain,dc=tld) into its elements (e.g.
-// ["cn=host", "dc=sub", "dc=domain", "dc=tld"]
-func dnSplit(dn string) []string {
-
-	// Define control characters
-	delimiter := ","
-	escapeChar := "\\"
+// TimeToInteger8 converts a time into an Integer8 timestamp, e.g. to build filters like (lastLogon>=...). The zero
+// time is returned as 0 (not set).
+func TimeToInteger8(t time.Time) int64 {
+	if t.IsZero() {
+		return 0
+	}
+	return t.Unix()*10000000 + int64(t.Nanosecond()/100) + integer8UnixEpoch
+}
 
-	// Prepare result
-	var splitPositions []int
+// GeneralizedTimeToTime parses a GeneralizedTime value (e.g. whenCreated) as defined by RFC 4517 section 3.3.13.
+// Minutes and seconds are optional, the last given unit may carry a fraction separated by '.' or ',' (e.g.
+// 20230101120000.0Z), and the time zone is either 'Z' or a differential like +0130 or -05.
+func GeneralizedTimeToTime(val string) (time.Time, error) {
 
-	// Find positions to split distinguished name at
-	prevChar := ""
-	for i, char := range dn {
-		if string(char) == delimiter && prevChar != escapeChar {
-			splitPositions = append(splitPositions, i)
+	// Parse mandatory date and hour
+	original := val
+	if len(val) < 11 {
+		return time.Time{}, fmt.Errorf("invalid GeneralizedTime '%s': too short", val)
+	}
+	var fields []int
+	for _, width := range []int{4, 2, 2, 2} {
+		n, errN := generalizedTimeDigits(val, width)
+		if errN != nil {
+			return time.Time{}, fmt.Errorf("invalid GeneralizedTime '%s': %w", original, errN)
 		}
-		prevChar = string(char)
+		fields = append(fields, n)
+		val = val[width:]
 	}
 
-	// Split distinguished name at found positions
-	var elements []string
-	currentPos := -1
-	for _, marker := range splitPositions {
-		element := dn[currentPos+1 : marker]
-		element = strings.Replace(element, "\\,", ",", -1)
-		elements = append(elements, element)
-		currentPos = marker
+	// Parse optional minute and second
+	for len(fields) < 6 && len(val) >= 2 && isDigit(val[0]) {
+		n, errN := generalizedTimeDigits(val, 2)
+		if errN != nil {
+			return time.Time{}, fmt.Errorf("invalid GeneralizedTime '%s': %w", original, errN)
+		}
+		fields = append(fields, n)
+		val = val[2:]
+	}
+	unit := []time.Duration{time.Hour, time.Minute, time.Second}[len(fields)-4]
+	for len(fields) < 6 {
+		fields = append(fields, 0)
+	}
+	if fields[1] < 1 || fields[1] > 12 || fields[3] > 23 || fields[4] > 59 || fields[5] > 60 { // 60 is a leap second
+		return time.Time{}, fmt.Errorf("invalid GeneralizedTime '%s': value out of range", original)
+	}
+	daysInMonth := time.Date(fields[0], time.Month(fields[1])+1, 0, 0, 0, 0, 0, time.UTC).Day()
+	if fields[2] < 1 || fields[2] > daysInMonth {
+		return time.Time{}, fmt.Errorf("invalid GeneralizedTime '%s': day out of range", original)
 	}
 
-	// Append remaining string
-	element := dn[currentPos+1:]
-	element = strings.Replace(element, "\\,", ",", -1)
-	elements = append(elements, element)
-
-	// Return elements
-	return elements
-}
-
-func Integer8ToTime(val int64) time.Time {
+	// Parse optional fraction of the last given unit
+	var fraction time.Duration
+	if len(val) > 0 && (val[0] == '.' || val[0] == ',') {
+		end := 1
+		for end < len(val) && isDigit(val[end]) {
+			end++
+		}
+		if end == 1 {
+			return time.Time{}, fmt.Errorf("invalid GeneralizedTime '%s': empty fraction", original)
+		}
+		f, _ := strconv.ParseFloat("0."+val[1:end], 64)
+		fraction = time.Duration(f * float64(unit))
+		val = val[end:]
+	}
 
-	// Translate to seconds
-	s := int(val / 10000000)
+	// Parse mandatory time zone
+	var location *time.Location
+	switch {
+	case val == "Z":
+		location = time.UTC
+	case (len(val) == 3 || len(val) == 5) && (val[0] == '+' || val[0] == '-'):
+		hours, errHours := generalizedTimeDigits(val[1:], 2)
+		if errHours != nil {
+			return time.Time{}, fmt.Errorf("invalid GeneralizedTime time zone '%s': %w", original, errHours)
+		}
+		minutes := 0
+		if len(val) == 5 {
+			var errMinutes error
+			minutes, errMinutes = generalizedTimeDigits(val[3:], 2)
+			if errMinutes != nil {
+				return time.Time{}, fmt.Errorf("invalid GeneralizedTime time zone '%s': %w", original, errMinutes)
+			}
+		}
+		if hours > 23 || minutes > 59 {
+			return time.Time{}, fmt.Errorf("invalid GeneralizedTime time zone '%s': value out of range", original)
+		}
+		offset := hours*3600 + minutes*60
+		if val[0] == '-' {
+			offset = -offset
+		}
+		location = time.FixedZone("", offset)
+	default:
+		return time.Time{}, fmt.Errorf("invalid GeneralizedTime '%s': unknown time zone", original)
+	}
 
-	// Add seconds to int8 start date
-	timestamp := time.Date(1601, 1, 1, 0, 0, s, 0, time.UTC)
+	// Assemble timestamp
+	timestamp := time.Date(fields[0], time.Month(fields[1]), fields[2], fields[3], fields[4], fields[5], 0, location)
+	timestamp = timestamp.Add(fraction)
 
 	// Return timestamp
-	return timestamp
+	return timestamp, nil
 }
 
-func GeneralizedTimeToTime(val string) (time.Time, error) {
-
-	// Parse timestamp
-	timestamp, err := time.Parse("20060102150405Z0700", val)
-	if err != nil {
-		return time.Time{}, err
+// generalizedTimeDigits parses the given number of leading decimal digits of a GeneralizedTime value
+func generalizedTimeDigits(val string, width int) (int, error) {
+	if len(val) < width {
+		return 0, fmt.Errorf("expected %d digits", width)
+	}
+	n := 0
+	for i := 0; i < width; i++ {
+		if !isDigit(val[i]) {
+			return 0, fmt.Errorf("unexpected character '%c'", val[i])
+		}
+		n = n*10 + int(val[i]-'0')
 	}
+	return n, nil
+}
 
-	// Return timestamp
-	return timestamp, nil
+// isDigit returns whether the given character is a decimal digit
+func isDigit(c byte) bool {
+	return c >= '0' && c <= '9'
 }
@@ -0,0 +1,70 @@
+package active_directory
+
+import (
+	"testing"
+	"time"
+)
+
+func TestGeneralizedTimeToTime(t *testing.T) {
+	tests := []struct {
+		name  string
+		input string
+		want  time.Time
+	}{
+		{"seconds", "20230101120000Z", time.Date(2023, 1, 1, 12, 0, 0, 0, time.UTC)},
+		{"zero fraction of second", "20230101120000.0Z", time.Date(2023, 1, 1, 12, 0, 0, 0, time.UTC)},
+		{"fraction of second", "20230101120000.123456Z", time.Date(2023, 1, 1, 12, 0, 0, 123456000, time.UTC)},
+		{"fraction of second with comma", "20230101120000,5Z", time.Date(2023, 1, 1, 12, 0, 0, 500000000, time.UTC)},
+		{"minute only", "202301011230Z", time.Date(2023, 1, 1, 12, 30, 0, 0, time.UTC)},
+		{"fraction of minute", "202301011230.25Z", time.Date(2023, 1, 1, 12, 30, 15, 0, time.UTC)},
+		{"hour only", "2023010112Z", time.Date(2023, 1, 1, 12, 0, 0, 0, time.UTC)},
+		{"fraction of hour", "2023010112.5Z", time.Date(2023, 1, 1, 12, 30, 0, 0, time.UTC)},
+		{"offset hours", "20230101120000-05", time.Date(2023, 1, 1, 17, 0, 0, 0, time.UTC)},
+		{"offset hours and minutes", "20230101120000+0130", time.Date(2023, 1, 1, 10, 30, 0, 0, time.UTC)},
+		{"fraction with offset", "202301011230,25+0130", time.Date(2023, 1, 1, 11, 0, 15, 0, time.UTC)},
+		{"leap day", "20240229000000Z", time.Date(2024, 2, 29, 0, 0, 0, 0, time.UTC)},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := GeneralizedTimeToTime(tt.input)
+			if err != nil {
+				t.Fatalf("GeneralizedTimeToTime(%q) returned error: %s", tt.input, err)
+			}
+			if !got.Equal(tt.want) {
+				t.Errorf("GeneralizedTimeToTime(%q) = %s, want %s", tt.input, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestGeneralizedTimeToTimeInvalid(t *testing.T) {
+	tests := []struct {
+		name  string
+		input string
+	}{
+		{"empty", ""},
+		{"missing time zone", "20230101120000"},
+		{"odd number of digits", "2023010112000Z"},
+		{"empty fraction", "20230101120000.Z"},
+		{"unknown time zone", "20230101120000UTC"},
+		{"offset without sign", "202301011200000130"},
+		{"offset with three digits", "20230101120000+013"},
+		{"offset out of range", "20230101120000+2400"},
+		{"month out of range", "20231301120000Z"},
+		{"day zero", "20230100120000Z"},
+		{"impossible date", "20230231120000Z"},
+		{"no leap day", "20230229120000Z"},
+		{"hour out of range", "20230101240000Z"},
+		{"minute out of range", "20230101126000Z"},
+		{"second out of range", "20230101120061Z"},
+		{"non-digit", "2023O101120000Z"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := GeneralizedTimeToTime(tt.input)
+			if err == nil {
+				t.Errorf("GeneralizedTimeToTime(%q) = %s, want error", tt.input, got)
+			}
+		})
+	}
+}
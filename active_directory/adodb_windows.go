@@ -2,18 +2,27 @@ package active_directory
 
 import (
 	"database/sql"
+	"fmt"
 	"github.com/go-ole/go-ole"
 	_ "github.com/mattn/go-adodb"
 	"github.com/siemens/GoScans/utils"
 	"math"
 	"reflect"
+	"strings"
 	"time"
 )
 
 // AdodbQuery queries the given Active Directory service with implicit Windows authentication and returns a
-// pointer to a populated Ad struct.
+// pointer to a populated Ad struct. The attributes to request are derived from the Ad struct's ldap tags, unless
+// they are given explicitly.
 // ATTENTION: Make sure searchCn / ldapAddress are sanitized if taken from user input, to avoid SQL injection attacks!
-func AdodbQuery(logger utils.Logger, searchCn string, searchDomain string) *Ad {
+func AdodbQuery(
+	logger utils.Logger,
+	searchCn string,
+	searchDomain string,
+	profile *AttributeProfile, // nil for the default Active Directory schema
+	attributes ...string, // Optional attributes to request instead of the ones defined by the Ad struct
+) *Ad {
 
 	logger.Debugf("Searching ADODB with implicit authentication for '%s'.", searchCn)
 
@@ -30,11 +39,16 @@ func AdodbQuery(logger utils.Logger, searchCn string, searchDomain string) *Ad {
 	// Convert domain name into distinguished name
 	baseDn := fqdnToDn(searchDomain)
 
+	// Prepare attributes to request, including the deployment specific ones
+	profile = profileOrDefault(profile)
+	if len(attributes) == 0 {
+		attributes = Attributes(Ad{})
+	}
+	columns := strings.Join(append(append([]string{}, attributes...), profile.ComputerAttributes...), ", ")
+
 	// Execute search
 	logger.Debugf("ADODB searching for computer '%s' in '%s'.", searchCn, searchDomain)
-	computerResult, errComputerResult := adDb.Query(`SELECT name, distinguishedName, dNSHostName, description, 
-		whenCreated, managedBy, lastLogon, pwdLastSet, location, operatingSystem, 
-		operatingSystemVersion, servicePrincipalName, isCriticalSystemObject 
+	computerResult, errComputerResult := adDb.Query(`SELECT ` + columns + ` 
 		FROM 'LDAP://` + searchDomain + `/` + baseDn + `' 
 		WHERE objectCategory = 'Computer' AND cn = '` + searchCn + `'`)
 	if errComputerResult != nil {
@@ -52,7 +66,7 @@ func AdodbQuery(logger utils.Logger, searchCn string, searchDomain string) *Ad {
 	for computerResult.Next() {
 
 		// Populate search result into AD struct
-		errPopulateComputer := adodbPopulate(&result, computerResult)
+		errPopulateComputer := adodbPopulate(&result, computerResult, nil, profile.ComputerAttributes)
 		if errPopulateComputer != nil {
 			logger.Errorf(
 				"ADODB search result for computer '%s:%s' could not be parsed: %s",
@@ -69,26 +83,37 @@ func AdodbQuery(logger utils.Logger, searchCn string, searchDomain string) *Ad {
 
 	// Execute user query, if managedBy is set
 	if len(result.ManagedBy) > 6 { // > 6 because there must be 'CN=' and 'DC=' at least
-		adodbExpand(logger, adDb, &result)
+		adodbExpand(logger, adDb, &result, profile)
 	}
 
 	// Prepare return data
 	return &result
 }
 
-// adodbExpand enriches the AD result struct with user data retrieved via a second ADODB query
-func adodbExpand(logger utils.Logger, adDb *sql.DB, result *Ad) {
+// adodbExpand enriches the AD result struct with owner data retrieved via a second ADODB query
+func adodbExpand(logger utils.Logger, adDb *sql.DB, result *Ad, profile *AttributeProfile) {
+
+	// Translate ManagedBy (distinguished name) into the domain to query
+	managedByDn, errDn := ParseDn(result.ManagedBy)
+	if errDn != nil {
+		logger.Warningf("Could not parse managedBy '%s': %s", result.ManagedBy, errDn)
+		return
+	}
+	newLdapAddress := managedByDn.Domain()
 
-	// Translate ManagedBy (distinguished name) into new ldap address, search CN and base DN
-	newLdapAddress, newSearchCn, newBaseDn := parseDn(result.ManagedBy)
+	// Prepare attributes to request, including the deployment specific ones
+	ownerAttributes := attributesOf(Ad{}, "managedBy")
+	for _, attribute := range profile.adFieldAttributes() {
+		ownerAttributes = append(ownerAttributes, attribute)
+	}
 
-	// Execute search
-	logger.Debugf("ADODB searching for user '%s' in '%s'.", newSearchCn, newLdapAddress)
-	userResult, errUserSearch := adDb.Query(`SELECT cn, department, siemens-gid
-		FROM 'LDAP://` + newLdapAddress + `/` + newBaseDn + `' 
-		WHERE objectCategory = 'User' AND cn = '` + newSearchCn + `'`)
+	// Execute search on the exact DN, instead of searching the whole domain
+	logger.Debugf("ADODB searching for owner '%s' in '%s'.", result.ManagedBy, newLdapAddress)
+	userResult, errUserSearch := adDb.Query(`SELECT ` + strings.Join(ownerAttributes, ", ") + `
+		FROM 'LDAP://` + newLdapAddress + `/` + adodbEscape(adsPathEscape(result.ManagedBy)) + `' 
+		WHERE objectClass = 'user' OR objectClass = 'group' OR objectClass = 'contact'`)
 	if errUserSearch != nil {
-		logger.Debugf("ADODB search for user '%s' in '%s' failed: %s", newSearchCn, newLdapAddress, errUserSearch)
+		logger.Debugf("ADODB search for owner '%s' in '%s' failed: %s", result.ManagedBy, newLdapAddress, errUserSearch)
 		return
 	}
 
@@ -99,12 +124,11 @@ func adodbExpand(logger utils.Logger, adDb *sql.DB, result *Ad) {
 	for userResult.Next() {
 
 		// Populate search result into AD struct
-		errPopulateUser := adodbPopulate(result, userResult)
+		errPopulateUser := adodbPopulate(result, userResult, profile.adFieldAttributes(), nil)
 		if errPopulateUser != nil {
 			logger.Errorf(
-				"ADODB search result for user '%s\\%s' could not be parsed: %s",
-				newLdapAddress,
-				newSearchCn,
+				"ADODB search result for owner '%s' could not be parsed: %s",
+				result.ManagedBy,
 				errPopulateUser,
 			)
 			return
@@ -115,12 +139,24 @@ func adodbExpand(logger utils.Logger, adDb *sql.DB, result *Ad) {
 	}
 }
 
+// adodbEscape escapes a value to be used as string literal within an ADODB query
+func adodbEscape(value string) string {
+	return strings.ReplaceAll(value, "'", "''")
+}
+
+// adsPathEscape escapes a distinguished name to be used within an ADsPath, where '/' separates server and DN
+func adsPathEscape(dn string) string {
+	return strings.ReplaceAll(dn, "/", "\\/")
+}
+
 func isNil(v interface{}) bool {
 	return v == nil || (reflect.ValueOf(v).Kind() == reflect.Ptr && reflect.ValueOf(v).IsNil())
 }
 
-// adodbPopulate fills a referenced result object with results from the ADODB search
-func adodbPopulate(result *Ad, sqlResult *sql.Rows) error {
+// adodbPopulate fills a referenced result object with results from the ADODB search. Fields are mapped to columns by
+// their ldap tag, unless fieldAttributes defines another attribute for the field name. Columns listed as extra are
+// stored in the result's Extra map.
+func adodbPopulate(result *Ad, sqlResult *sql.Rows, fieldAttributes map[string]string, extra []string) error {
 
 	// Read column types
 	columns, errC := sqlResult.ColumnTypes()
@@ -154,8 +190,11 @@ func adodbPopulate(result *Ad, sqlResult *sql.Rows) error {
 		// Get the attribute, returns https://golang.org/pkg/reflect/#StructField
 		attr := t.Field(i)
 
-		// Get the attribute's tag value
-		tag := attr.Tag.Get("ldap")
+		// Get the attribute's tag value, or the attribute configured for the field
+		tag := ldapTagName(attr)
+		if configured, ok := fieldAttributes[attr.Name]; ok {
+			tag = configured
+		}
 
 		// No tag defined
 		if len(tag) == 0 {
@@ -190,6 +229,24 @@ func adodbPopulate(result *Ad, sqlResult *sql.Rows) error {
 		case string:
 			reflect.ValueOf(result).Elem().Field(i).SetString(s.Interface().(string))
 
+		case int32:
+			// Integers (e.g. userAccountControl) might be bit masks stored in unsigned fields
+			field := reflect.ValueOf(result).Elem().Field(i)
+			switch field.Kind() {
+			case reflect.Uint, reflect.Uint32, reflect.Uint64:
+				field.SetUint(uint64(uint32(s.Interface().(int32))))
+			case reflect.Int, reflect.Int32, reflect.Int64:
+				field.SetInt(int64(s.Interface().(int32)))
+			default:
+			}
+
+		case []byte:
+			// Binary values (e.g. objectSid, objectGUID) are decoded like their LDAP representation
+			errDecode := decodeField(reflect.ValueOf(result).Elem().Field(i), [][]byte{s.Interface().([]byte)})
+			if errDecode != nil {
+				return fmt.Errorf("attribute '%s': %w", tag, errDecode)
+			}
+
 		case time.Time:
 			reflect.ValueOf(result).Elem().Field(i).Set(reflect.ValueOf(s.Interface().(time.Time)))
 
@@ -266,6 +323,42 @@ func adodbPopulate(result *Ad, sqlResult *sql.Rows) error {
 		}
 	}
 
+	// Copy extra columns
+	for _, attribute := range extra {
+		index, exists := names[attribute]
+		if !exists {
+			continue
+		}
+		v := values[index].(*interface{})
+		if isNil(*v) {
+			continue
+		}
+		if result.Extra == nil {
+			result.Extra = map[string][]string{}
+		}
+		result.Extra[attribute] = adodbStrings(*v)
+	}
+
 	// Return nil as everything went fine
 	return nil
 }
+
+// adodbStrings converts a single or multi-valued ADODB column value into a slice of strings
+func adodbStrings(v interface{}) []string {
+	vt, ok := v.(*ole.VARIANT)
+	if !ok {
+		return []string{fmt.Sprint(v)}
+	}
+	if vt.VT&ole.VT_ARRAY == 0 {
+		return []string{fmt.Sprint(vt.Value())}
+	}
+	vtsArray := vt.ToArray()
+	if vtsArray == nil {
+		return nil
+	}
+	var strs []string
+	for _, el := range vtsArray.ToValueArray() {
+		strs = append(strs, fmt.Sprint(el))
+	}
+	return strs
+}
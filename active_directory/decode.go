@@ -0,0 +1,271 @@
+package active_directory
+
+import (
+	"errors"
+	"fmt"
+	"reflect"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/go-ldap/ldap/v3"
+	"github.com/siemens/GoScans/utils"
+)
+
+var (
+	timeType = reflect.TypeOf(time.Time{})
+	sidType  = reflect.TypeOf(Sid(""))
+	guidType = reflect.TypeOf(Guid(""))
+)
+
+// Unmarshal populates the struct pointed to by v with the attributes of an LDAP entry. Fields are mapped to
+// attributes by their `ldap:"attributeName"` tag, fields without tag or with tag "-" are skipped, as are fields
+// read from referenced objects (e.g. `ldap:"cn,managedBy"`). Supported field
+// types are string, []string, bool, signed and unsigned integers, time.Time (from Integer8 or GeneralizedTime),
+// []byte, [][]byte, Sid and Guid (from their binary representation). Fields of attributes missing in the entry
+// are left untouched. Values that cannot be decoded are reported as joined error after all other fields have
+// been populated. Attributes the server returned in ranges (e.g. member;range=0-1499) can only be decoded
+// partially and are reported as error, use LdapSession.Unmarshal to read them completely.
+func Unmarshal(entry *ldap.Entry, v any) error {
+	return unmarshalEntry(entry, v, nil, nil)
+}
+
+// Unmarshal works like the package level Unmarshal, but reads attributes the server returned in ranges (e.g.
+// member;range=0-1499) completely, by issuing follow-up queries on the entry's DN.
+func (s *LdapSession) Unmarshal(logger utils.Logger, entry *ldap.Entry, v any) error {
+	return unmarshalEntry(entry, v, nil, s.rangeReader(logger))
+}
+
+// rangeReader reads all values of an attribute the server returned in ranges
+type rangeReader func(entry *ldap.Entry, attribute string) ([][]byte, error)
+
+// rangeReader returns a range reader using the session for follow-up queries
+func (s *LdapSession) rangeReader(logger utils.Logger) rangeReader {
+	return func(entry *ldap.Entry, attribute string) ([][]byte, error) {
+		return ldapRangedValues(logger, s, entry, attribute)
+	}
+}
+
+// unmarshalEntry works like Unmarshal, but allows to map fields to other attributes than their tags define,
+// by field name. Ranged attributes are read via the given range reader, if any.
+func unmarshalEntry(entry *ldap.Entry, v any, fieldAttributes map[string]string, readRange rangeReader) error {
+
+	// Check target
+	rv := reflect.ValueOf(v)
+	if rv.Kind() != reflect.Ptr || rv.IsNil() || rv.Elem().Kind() != reflect.Struct {
+		return fmt.Errorf("target must be a non-nil pointer to a struct, got %T", v)
+	}
+	rv = rv.Elem()
+	rt := rv.Type()
+
+	// Enumerate the struct fields in order to fill each one with the appropriate attribute values
+	var errs []error
+	for i := 0; i < rt.NumField(); i++ {
+		field := rt.Field(i)
+		if !field.IsExported() {
+			continue
+		}
+
+		// Get the attribute name from the tag, or from the field mapping
+		attribute, source := ldapTag(field)
+		if source != "" {
+			continue
+		}
+		if configured, ok := fieldAttributes[field.Name]; ok {
+			attribute = configured
+		}
+		if attribute == "" {
+			continue
+		}
+
+		// Read raw attribute values, the DN is not necessarily returned as attribute
+		values := entry.GetRawAttributeValues(attribute)
+		if len(values) == 0 && strings.EqualFold(attribute, "distinguishedName") && entry.DN != "" {
+			values = [][]byte{[]byte(entry.DN)}
+		}
+		if len(values) == 0 {
+			partial, _, ranged := rangedAttribute(entry, attribute)
+			if ranged && readRange != nil {
+				var errRange error
+				values, errRange = readRange(entry, attribute)
+				if errRange != nil {
+					errs = append(errs, fmt.Errorf("attribute '%s': %w", attribute, errRange))
+				}
+			} else if ranged {
+				values = partial
+				errs = append(errs, fmt.Errorf("attribute '%s': only first range of values returned", attribute))
+			}
+		}
+		if len(values) == 0 {
+			continue
+		}
+
+		// Decode values into field
+		errField := decodeField(rv.Field(i), values)
+		if errField != nil {
+			errs = append(errs, fmt.Errorf("attribute '%s': %w", attribute, errField))
+		}
+	}
+
+	// Return decoding errors, if any
+	return errors.Join(errs...)
+}
+
+// ldapTagName returns the attribute name of a struct field's ldap tag, ignoring any options after a comma
+func ldapTagName(field reflect.StructField) string {
+	name, _ := ldapTag(field)
+	return name
+}
+
+// ldapTag returns the attribute name and the source option of a struct field's ldap tag. The source option
+// marks fields read from a referenced object instead of the object itself (e.g. `ldap:"cn,managedBy"`).
+func ldapTag(field reflect.StructField) (string, string) {
+	tag := field.Tag.Get("ldap")
+	name, source, _ := strings.Cut(tag, ",")
+	if name == "-" {
+		return "", ""
+	}
+	return name, source
+}
+
+// Attributes returns the names of the LDAP attributes to request for the given struct (or pointer to struct), as
+// defined by the ldap tags of its fields. Fields read from referenced objects are not included.
+func Attributes(v any) []string {
+	return attributesOf(v, "")
+}
+
+// attributesOf returns the names of the LDAP attributes of the fields with the given source option. An empty
+// source selects the fields of the object itself.
+func attributesOf(v any, source string) []string {
+	rt := reflect.TypeOf(v)
+	for rt.Kind() == reflect.Ptr {
+		rt = rt.Elem()
+	}
+	if rt.Kind() != reflect.Struct {
+		return nil
+	}
+	var attributes []string
+	for i := 0; i < rt.NumField(); i++ {
+		name, fieldSource := ldapTag(rt.Field(i))
+		if name != "" && fieldSource == source && rt.Field(i).IsExported() {
+			attributes = append(attributes, name)
+		}
+	}
+	return attributes
+}
+
+// decodeField decodes the raw attribute values into the given field according to its type
+func decodeField(fv reflect.Value, values [][]byte) error {
+
+	// Decode special types
+	switch fv.Type() {
+	case timeType:
+		t, err := decodeTime(string(values[0]))
+		if err != nil {
+			return err
+		}
+		fv.Set(reflect.ValueOf(t))
+		return nil
+	case sidType:
+		sid, err := DecodeSid(values[0])
+		if err != nil {
+			return err
+		}
+		fv.SetString(string(sid))
+		return nil
+	case guidType:
+		guid, err := DecodeGuid(values[0])
+		if err != nil {
+			return err
+		}
+		fv.SetString(string(guid))
+		return nil
+	}
+
+	// Decode basic types
+	switch fv.Kind() {
+	case reflect.String:
+		fv.SetString(string(values[0]))
+
+	case reflect.Bool:
+		b, err := strconv.ParseBool(string(values[0]))
+		if err != nil {
+			return err
+		}
+		fv.SetBool(b)
+
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		n, err := strconv.ParseInt(string(values[0]), 10, fv.Type().Bits())
+		if err != nil {
+			return err
+		}
+		fv.SetInt(n)
+
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		// Some attributes (e.g. userAccountControl) are signed 32 bit integers bearing flags, take their bits as they are
+		val := string(values[0])
+		if fv.Type().Bits() == 32 && strings.HasPrefix(val, "-") {
+			n, err := strconv.ParseInt(val, 10, 32)
+			if err != nil {
+				return err
+			}
+			fv.SetUint(uint64(uint32(int32(n))))
+			break
+		}
+		n, err := strconv.ParseUint(val, 10, fv.Type().Bits())
+		if err != nil {
+			return err
+		}
+		fv.SetUint(n)
+
+	case reflect.Slice:
+		return decodeSlice(fv, values)
+
+	default:
+		return fmt.Errorf("unsupported field type %s", fv.Type())
+	}
+
+	// Return nil as everything went fine
+	return nil
+}
+
+// decodeSlice decodes all raw attribute values into a slice field
+func decodeSlice(fv reflect.Value, values [][]byte) error {
+
+	// A byte slice takes the raw value
+	if fv.Type().Elem().Kind() == reflect.Uint8 {
+		fv.SetBytes(append([]byte{}, values[0]...))
+		return nil
+	}
+
+	// Decode each value as element
+	slice := reflect.MakeSlice(fv.Type(), len(values), len(values))
+	for i, value := range values {
+		if slice.Index(i).Kind() == reflect.Slice && slice.Index(i).Type().Elem().Kind() == reflect.Uint8 {
+			slice.Index(i).SetBytes(append([]byte{}, value...))
+			continue
+		}
+		err := decodeField(slice.Index(i), [][]byte{value})
+		if err != nil {
+			return err
+		}
+	}
+	fv.Set(slice)
+
+	// Return nil as everything went fine
+	return nil
+}
+
+// decodeTime decodes a timestamp given either as Integer8 (e.g. lastLogon) or as GeneralizedTime (e.g. whenCreated).
+// Active Directory always returns GeneralizedTime with time zone, so plain integers are Integer8 values.
+func decodeTime(val string) (time.Time, error) {
+
+	// Parse Integer8
+	int8Val, errInt := strconv.ParseInt(val, 10, 64)
+	if errInt == nil {
+		return Integer8ToTime(int8Val), nil
+	}
+
+	// Parse GeneralizedTime
+	return GeneralizedTimeToTime(val)
+}
@@ -0,0 +1,45 @@
+package active_directory
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestDecodeFieldUnsigned(t *testing.T) {
+	tests := []struct {
+		name    string
+		field   any
+		input   string
+		want    uint64
+		wantErr bool
+	}{
+		{"uint8", uint8(0), "200", 200, false},
+		{"uint8 overflow", uint8(0), "300", 0, true},
+		{"uint16 overflow", uint16(0), "65536", 0, true},
+		{"uint32", uint32(0), "4294967295", 4294967295, false},
+		{"uint32 negative flags", uint32(0), "-2147483648", 0x80000000, false},
+		{"uint32 negative overflow", uint32(0), "-2147483649", 0, true},
+		{"uint64", uint64(0), "18446744073709551615", 18446744073709551615, false},
+		{"uint64 negative", uint64(0), "-1", 0, true},
+		{"uint8 negative", uint8(0), "-1", 0, true},
+		{"user account control", UserAccountControl(0), "-2147479552", 0x80001000, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			fv := reflect.New(reflect.TypeOf(tt.field)).Elem()
+			err := decodeField(fv, [][]byte{[]byte(tt.input)})
+			if tt.wantErr {
+				if err == nil {
+					t.Errorf("decodeField(%q) = %d, want error", tt.input, fv.Uint())
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("decodeField(%q) returned error: %s", tt.input, err)
+			}
+			if fv.Uint() != tt.want {
+				t.Errorf("decodeField(%q) = %d, want %d", tt.input, fv.Uint(), tt.want)
+			}
+		})
+	}
+}
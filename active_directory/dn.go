@@ -0,0 +1,116 @@
+package active_directory
+
+import (
+	"strings"
+
+	"github.com/go-ldap/ldap/v3"
+)
+
+// Dn is a distinguished name parsed according to RFC 4514 (e.g. cn=host,ou=servers,dc=sub,dc=domain,dc=tld). The
+// first RDN is the object itself, the last one the root of the directory.
+type Dn struct {
+	dn *ldap.DN
+}
+
+// ParseDn parses the string representation of a distinguished name, including escaped characters (e.g. '\,',
+// '\+', '\"', '\\' or hex escapes like '\2C') and multi-valued RDNs (e.g. cn=a+uid=b,dc=tld)
+func ParseDn(dn string) (*Dn, error) {
+	parsed, err := ldap.ParseDN(dn)
+	if err != nil {
+		return nil, err
+	}
+	return &Dn{dn: parsed}, nil
+}
+
+// Rdns returns the relative distinguished names, starting with the one of the object itself
+func (d *Dn) Rdns() []*ldap.RelativeDN {
+	return d.dn.RDNs
+}
+
+// IsEmpty returns whether the DN has no RDNs, as e.g. the one of the rootDSE
+func (d *Dn) IsEmpty() bool {
+	return len(d.dn.RDNs) == 0
+}
+
+// RdnType returns the attribute type of the object's own RDN (e.g. 'cn'). For multi-valued RDNs, the first
+// attribute type is returned.
+func (d *Dn) RdnType() string {
+	if d.IsEmpty() || len(d.dn.RDNs[0].Attributes) == 0 {
+		return ""
+	}
+	return d.dn.RDNs[0].Attributes[0].Type
+}
+
+// RdnValue returns the unescaped attribute value of the object's own RDN (e.g. 'host'). For multi-valued RDNs,
+// the first attribute value is returned.
+func (d *Dn) RdnValue() string {
+	if d.IsEmpty() || len(d.dn.RDNs[0].Attributes) == 0 {
+		return ""
+	}
+	return d.dn.RDNs[0].Attributes[0].Value
+}
+
+// Value returns the unescaped value of the given attribute type within the object's own RDN, or an empty string
+// if the RDN does not contain the attribute type
+func (d *Dn) Value(attrType string) string {
+	if d.IsEmpty() {
+		return ""
+	}
+	for _, attr := range d.dn.RDNs[0].Attributes {
+		if strings.EqualFold(attr.Type, attrType) {
+			return attr.Value
+		}
+	}
+	return ""
+}
+
+// DomainDn returns the trailing domain components of the DN (e.g. dc=sub,dc=domain,dc=tld)
+func (d *Dn) DomainDn() *Dn {
+	start := len(d.dn.RDNs)
+	for i := len(d.dn.RDNs) - 1; i >= 0; i-- {
+		rdn := d.dn.RDNs[i]
+		if len(rdn.Attributes) != 1 || !strings.EqualFold(rdn.Attributes[0].Type, "dc") {
+			break
+		}
+		start = i
+	}
+	return &Dn{dn: &ldap.DN{RDNs: d.dn.RDNs[start:]}}
+}
+
+// Domain returns the DNS domain name made up by the trailing domain components of the DN (e.g. sub.domain.tld)
+func (d *Dn) Domain() string {
+	var segments []string
+	for _, rdn := range d.DomainDn().dn.RDNs {
+		segments = append(segments, strings.ToLower(rdn.Attributes[0].Value))
+	}
+	return strings.Join(segments, ".")
+}
+
+// Parent returns the DN of the object's parent container, or an empty DN for the root
+func (d *Dn) Parent() *Dn {
+	if d.IsEmpty() {
+		return d
+	}
+	return &Dn{dn: &ldap.DN{RDNs: d.dn.RDNs[1:]}}
+}
+
+// Child returns the DN of the object with the given single-valued RDN within this object
+func (d *Dn) Child(attrType string, value string) *Dn {
+	rdn := &ldap.RelativeDN{Attributes: []*ldap.AttributeTypeAndValue{{Type: attrType, Value: value}}}
+	return &Dn{dn: &ldap.DN{RDNs: append([]*ldap.RelativeDN{rdn}, d.dn.RDNs...)}}
+}
+
+// Equal returns whether both DNs name the same object, ignoring case
+func (d *Dn) Equal(other *Dn) bool {
+	return d.dn.EqualFold(other.dn)
+}
+
+// IsAncestorOf returns whether the other DN is located below this DN, ignoring case
+func (d *Dn) IsAncestorOf(other *Dn) bool {
+	return d.dn.AncestorOfFold(other.dn)
+}
+
+// String returns the canonical string representation with lower case attribute types and escaped values
+func (d *Dn) String() string {
+	return d.dn.String()
+}
@@ -0,0 +1,476 @@
+package active_directory
+
+import (
+	"context"
+	"encoding/binary"
+	"fmt"
+	"net"
+	"strings"
+	"time"
+
+	"github.com/go-ldap/ldap/v3"
+	"github.com/siemens/GoScans/utils"
+)
+
+// DnsRecordType is the type of a DNS resource record, as defined by RFC 1035 and successors
+type DnsRecordType uint16
+
+// DNS record types decoded from AD-integrated zones, see MS-DNSP section 2.2.2.1.1
+const (
+	DnsTypeA     DnsRecordType = 1
+	DnsTypeNs    DnsRecordType = 2
+	DnsTypeCname DnsRecordType = 5
+	DnsTypeSoa   DnsRecordType = 6
+	DnsTypePtr   DnsRecordType = 12
+	DnsTypeMx    DnsRecordType = 15
+	DnsTypeTxt   DnsRecordType = 16
+	DnsTypeAaaa  DnsRecordType = 28
+	DnsTypeSrv   DnsRecordType = 33
+)
+
+// String returns the mnemonic of the record type (e.g. AAAA), or TYPE<n> for unknown types as per RFC 3597
+func (t DnsRecordType) String() string {
+	switch t {
+	case DnsTypeA:
+		return "A"
+	case DnsTypeNs:
+		return "NS"
+	case DnsTypeCname:
+		return "CNAME"
+	case DnsTypeSoa:
+		return "SOA"
+	case DnsTypePtr:
+		return "PTR"
+	case DnsTypeMx:
+		return "MX"
+	case DnsTypeTxt:
+		return "TXT"
+	case DnsTypeAaaa:
+		return "AAAA"
+	case DnsTypeSrv:
+		return "SRV"
+	default:
+		return fmt.Sprintf("TYPE%d", uint16(t))
+	}
+}
+
+// DnsZone describes a DNS zone stored in Active Directory
+type DnsZone struct {
+	Name              string // Zone name, e.g. example.com or 1.168.192.in-addr.arpa
+	DistinguishedName string
+	Partition         string // Naming context holding the zone, e.g. DC=DomainDnsZones,DC=example,DC=com
+}
+
+// DnsSoa holds the data of an SOA record
+type DnsSoa struct {
+	PrimaryServer string
+	Admin         string
+	Serial        uint32
+	Refresh       uint32
+	Retry         uint32
+	Expire        uint32
+	MinimumTtl    uint32
+}
+
+// DnsRecord is a resource record decoded from the dnsRecord attribute of a dnsNode object. Only the fields
+// matching the record type are set.
+type DnsRecord struct {
+	Name      string // Fully qualified owner name, without trailing dot
+	Type      DnsRecordType
+	Ttl       uint32
+	Serial    uint32    // Serial of the zone at the time the record was last written
+	Timestamp time.Time // Last refresh of dynamically registered records, zero for static records
+	Address   net.IP    // A, AAAA
+	Target    string    // NS, CNAME, PTR, MX, SRV
+	Priority  uint16    // MX (preference), SRV
+	Weight    uint16    // SRV
+	Port      uint16    // SRV
+	Texts     []string  // TXT
+	Soa       *DnsSoa   // SOA
+	Raw       []byte    // Record data of types that are not decoded
+}
+
+// dnsZoneContainers returns the containers AD-integrated zones may be stored in: the domain and forest DNS
+// application partitions, and the legacy location within the domain partition
+func dnsZoneContainers(session *LdapSession) []string {
+	return []string{
+		"CN=MicrosoftDNS,DC=DomainDnsZones," + session.BaseDn(),
+		"CN=MicrosoftDNS,DC=ForestDnsZones," + session.RootDomainDn(),
+		"CN=MicrosoftDNS,CN=System," + session.BaseDn(),
+	}
+}
+
+// EnumerateDnsZones lists the DNS zones stored in Active Directory. Partitions that do not exist or cannot be
+// read are skipped.
+func EnumerateDnsZones(logger utils.Logger, session *LdapSession) ([]DnsZone, error) {
+	var zones []DnsZone
+	var errLast error
+	for _, container := range dnsZoneContainers(session) {
+
+		// Search zones within container
+		zoneSearch := ldap.NewSearchRequest(
+			container, // The base dn to search
+			ldap.ScopeSingleLevel,
+			ldap.NeverDerefAliases,
+			0,
+			0,
+			false,
+			"(objectClass=dnsZone)", // The filter to apply
+			[]string{"dc"},
+			nil,
+		)
+		zoneResult, errSearch := session.searchPaged(logger, zoneSearch)
+		if ldap.IsErrorWithCode(errSearch, ldap.LDAPResultNoSuchObject) {
+			continue
+		} else if errSearch != nil {
+			logger.Debugf("LDAP search for DNS zones in '%s' failed: %s", container, errSearch)
+			errLast = errSearch
+			continue
+		}
+
+		// Collect zones
+		partition := strings.TrimPrefix(container, "CN=MicrosoftDNS,")
+		for _, entry := range zoneResult.Entries {
+			zones = append(zones, DnsZone{
+				Name:              entry.GetAttributeValue("dc"),
+				DistinguishedName: entry.DN,
+				Partition:         partition,
+			})
+		}
+	}
+
+	// Return error only if no zone could be found at all
+	if len(zones) == 0 && errLast != nil {
+		return nil, errLast
+	}
+	return zones, nil
+}
+
+// ReadDnsZone reads all records of a DNS zone stored in Active Directory. Deleted (tombstoned) nodes are skipped,
+// as are records that cannot be decoded.
+func ReadDnsZone(logger utils.Logger, session *LdapSession, zone DnsZone) ([]DnsRecord, error) {
+
+	// Search nodes of the zone
+	nodeSearch := ldap.NewSearchRequest(
+		zone.DistinguishedName, // The base dn to search
+		ldap.ScopeSingleLevel,
+		ldap.NeverDerefAliases,
+		0,
+		0,
+		false,
+		"(objectClass=dnsNode)", // The filter to apply
+		[]string{"dc", "dnsRecord", "dNSTombstoned"},
+		nil,
+	)
+	nodeResult, errSearch := session.searchPaged(logger, nodeSearch)
+	if errSearch != nil {
+		return nil, errSearch
+	}
+
+	// Decode records of each node
+	var records []DnsRecord
+	for _, entry := range nodeResult.Entries {
+		records = append(records, dnsNodeRecords(logger, entry, zone.Name)...)
+	}
+
+	// Return records
+	return records, nil
+}
+
+// dnsNodeRecords decodes the records of a dnsNode entry
+func dnsNodeRecords(logger utils.Logger, entry *ldap.Entry, zoneName string) []DnsRecord {
+
+	// Skip deleted nodes
+	if strings.EqualFold(entry.GetAttributeValue("dNSTombstoned"), "TRUE") {
+		return nil
+	}
+
+	// Derive fully qualified name, '@' denotes the zone apex
+	name := entry.GetAttributeValue("dc")
+	if name == "@" || name == "" {
+		name = zoneName
+	} else {
+		name = name + "." + zoneName
+	}
+
+	// Decode records
+	var records []DnsRecord
+	for _, value := range entry.GetRawAttributeValues("dnsRecord") {
+		record, errRecord := DecodeDnsRecord(value)
+		if errRecord != nil {
+			logger.Debugf("DNS record of '%s' could not be decoded: %s", name, errRecord)
+			continue
+		}
+		if record.Type == 0 { // Tombstone record of a deleted node
+			continue
+		}
+		record.Name = name
+		records = append(records, record)
+	}
+	return records
+}
+
+// DecodeDnsRecord decodes the binary value of a dnsRecord attribute, see MS-DNSP section 2.3.2.2. The name of the
+// record is not part of the value, but taken from the dnsNode object holding it.
+func DecodeDnsRecord(b []byte) (DnsRecord, error) {
+
+	// Decode header, the TTL is the only big endian field
+	if len(b) < 24 {
+		return DnsRecord{}, fmt.Errorf("DNS record too short: %d bytes", len(b))
+	}
+	dataLength := int(binary.LittleEndian.Uint16(b[0:2]))
+	if len(b) < 24+dataLength {
+		return DnsRecord{}, fmt.Errorf("DNS record data length %d exceeds %d bytes", dataLength, len(b)-24)
+	}
+	record := DnsRecord{
+		Type:   DnsRecordType(binary.LittleEndian.Uint16(b[2:4])),
+		Serial: binary.LittleEndian.Uint32(b[8:12]),
+		Ttl:    binary.BigEndian.Uint32(b[12:16]),
+	}
+	if hours := binary.LittleEndian.Uint32(b[20:24]); hours != 0 {
+		record.Timestamp = Integer8ToTime(int64(hours) * int64(time.Hour/100)) // Hours since 1601-01-01 UTC
+	}
+	data := b[24 : 24+dataLength]
+
+	// Decode data by type
+	var errData error
+	switch record.Type {
+	case DnsTypeA:
+		if len(data) != net.IPv4len {
+			return record, fmt.Errorf("A record data must be 4 bytes, got %d", len(data))
+		}
+		record.Address = net.IP(append([]byte{}, data...))
+	case DnsTypeAaaa:
+		if len(data) != net.IPv6len {
+			return record, fmt.Errorf("AAAA record data must be 16 bytes, got %d", len(data))
+		}
+		record.Address = net.IP(append([]byte{}, data...))
+	case DnsTypeNs, DnsTypeCname, DnsTypePtr:
+		record.Target, _, errData = dnsCountName(data)
+	case DnsTypeMx:
+		if len(data) < 2 {
+			return record, fmt.Errorf("MX record data too short")
+		}
+		record.Priority = binary.BigEndian.Uint16(data[0:2])
+		record.Target, _, errData = dnsCountName(data[2:])
+	case DnsTypeSrv:
+		if len(data) < 6 {
+			return record, fmt.Errorf("SRV record data too short")
+		}
+		record.Priority = binary.BigEndian.Uint16(data[0:2])
+		record.Weight = binary.BigEndian.Uint16(data[2:4])
+		record.Port = binary.BigEndian.Uint16(data[4:6])
+		record.Target, _, errData = dnsCountName(data[6:])
+	case DnsTypeTxt:
+		for len(data) > 0 {
+			length := int(data[0])
+			if len(data) < 1+length {
+				return record, fmt.Errorf("TXT record string exceeds data")
+			}
+			record.Texts = append(record.Texts, string(data[1:1+length]))
+			data = data[1+length:]
+		}
+	case DnsTypeSoa:
+		record.Soa, errData = dnsSoa(data)
+	default:
+		record.Raw = append([]byte{}, data...)
+	}
+	if errData != nil {
+		return record, fmt.Errorf("%s record: %w", record.Type, errData)
+	}
+
+	// Return record
+	return record, nil
+}
+
+// dnsSoa decodes the data of an SOA record, see MS-DNSP section 2.2.2.2.4.3
+func dnsSoa(data []byte) (*DnsSoa, error) {
+	if len(data) < 20 {
+		return nil, fmt.Errorf("data too short")
+	}
+	soa := &DnsSoa{
+		Serial:     binary.BigEndian.Uint32(data[0:4]),
+		Refresh:    binary.BigEndian.Uint32(data[4:8]),
+		Retry:      binary.BigEndian.Uint32(data[8:12]),
+		Expire:     binary.BigEndian.Uint32(data[12:16]),
+		MinimumTtl: binary.BigEndian.Uint32(data[16:20]),
+	}
+	var n int
+	var err error
+	soa.PrimaryServer, n, err = dnsCountName(data[20:])
+	if err != nil {
+		return nil, err
+	}
+	soa.Admin, _, err = dnsCountName(data[20+n:])
+	if err != nil {
+		return nil, err
+	}
+	return soa, nil
+}
+
+// dnsCountName decodes a DNS_COUNT_NAME structure into a dotted name without trailing dot and returns the number
+// of bytes consumed, see MS-DNSP section 2.2.2.2.2
+func dnsCountName(data []byte) (string, int, error) {
+	if len(data) < 2 {
+		return "", 0, fmt.Errorf("name too short")
+	}
+	length := int(data[0])
+	labelCount := int(data[1])
+	if len(data) < 2+length {
+		return "", 0, fmt.Errorf("name length %d exceeds data", length)
+	}
+	raw := data[2 : 2+length]
+	labels := make([]string, 0, labelCount)
+	for i := 0; i < labelCount; i++ {
+		if len(raw) == 0 || len(raw) < 1+int(raw[0]) {
+			return "", 0, fmt.Errorf("label exceeds name")
+		}
+		labels = append(labels, string(raw[1:1+int(raw[0])]))
+		raw = raw[1+int(raw[0]):]
+	}
+	return strings.Join(labels, "."), 2 + length, nil
+}
+
+// LdapResolver resolves host names and addresses via the DNS zones stored in Active Directory, for networks
+// where the DNS servers are not reachable but LDAP is. It can be used as ResolveOptions.Resolver.
+type LdapResolver struct {
+	logger  utils.Logger
+	session *LdapSession
+	zones   []DnsZone
+}
+
+// NewLdapResolver creates a resolver reading the DNS zones of the given session
+func NewLdapResolver(logger utils.Logger, session *LdapSession) *LdapResolver {
+	return &LdapResolver{
+		logger:  logger,
+		session: session,
+	}
+}
+
+// dnsMaxCnameChain limits the number of CNAME records followed when resolving a host name
+const dnsMaxCnameChain = 8
+
+// LookupIPAddr returns the A and AAAA records of the given host name, following CNAME records
+func (r *LdapResolver) LookupIPAddr(_ context.Context, host string) ([]net.IPAddr, error) {
+	name := strings.TrimSuffix(host, ".")
+	for i := 0; i < dnsMaxCnameChain; i++ {
+
+		// Read records of the name
+		records, errRecords := r.lookup(name)
+		if errRecords != nil {
+			return nil, errRecords
+		}
+
+		// Collect addresses, or follow alias
+		var addresses []net.IPAddr
+		var alias string
+		for _, record := range records {
+			switch record.Type {
+			case DnsTypeA, DnsTypeAaaa:
+				addresses = append(addresses, net.IPAddr{IP: record.Address})
+			case DnsTypeCname:
+				alias = record.Target
+			default:
+			}
+		}
+		if len(addresses) > 0 {
+			return addresses, nil
+		}
+		if alias == "" {
+			return nil, &net.DNSError{Err: "no such host", Name: host, IsNotFound: true}
+		}
+		name = alias
+	}
+	return nil, &net.DNSError{Err: "too many CNAME records", Name: host}
+}
+
+// LookupAddr returns the host names the given IP address' PTR records point to
+func (r *LdapResolver) LookupAddr(_ context.Context, addr string) ([]string, error) {
+
+	// Derive reverse name
+	ip := net.ParseIP(addr)
+	if ip == nil {
+		return nil, &net.DNSError{Err: "unrecognized address", Name: addr}
+	}
+	reverseName := dnsReverseName(ip)
+
+	// Read PTR records
+	records, errRecords := r.lookup(reverseName)
+	if errRecords != nil {
+		return nil, errRecords
+	}
+	var names []string
+	for _, record := range records {
+		if record.Type == DnsTypePtr {
+			names = append(names, record.Target)
+		}
+	}
+	if len(names) == 0 {
+		return nil, &net.DNSError{Err: "no such host", Name: addr, IsNotFound: true}
+	}
+	return names, nil
+}
+
+// lookup reads the records of a fully qualified name from the most specific zone containing it
+func (r *LdapResolver) lookup(name string) ([]DnsRecord, error) {
+
+	// Enumerate zones once
+	if r.zones == nil {
+		zones, errZones := EnumerateDnsZones(r.logger, r.session)
+		if errZones != nil {
+			return nil, errZones
+		}
+		r.zones = zones
+	}
+
+	// Select most specific zone
+	var zone *DnsZone
+	for i, candidate := range r.zones {
+		if !dnsInZone(name, candidate.Name) {
+			continue
+		}
+		if zone == nil || len(candidate.Name) > len(zone.Name) {
+			zone = &r.zones[i]
+		}
+	}
+	if zone == nil {
+		return nil, &net.DNSError{Err: "no zone found", Name: name, IsNotFound: true}
+	}
+
+	// Read the node directly
+	nodeName := "@"
+	if len(name) > len(zone.Name) {
+		nodeName = name[:len(name)-len(zone.Name)-1]
+	}
+	entry, errNode := resolveReference(
+		r.logger, r.session, "DC="+ldap.EscapeDN(nodeName)+","+zone.DistinguishedName, "(objectClass=dnsNode)",
+		[]string{"dc", "dnsRecord", "dNSTombstoned"},
+	)
+	if errNode != nil {
+		return nil, errNode
+	}
+	if entry == nil {
+		return nil, nil
+	}
+	return dnsNodeRecords(r.logger, entry, zone.Name), nil
+}
+
+// dnsInZone returns whether the name equals the zone name or is a subdomain of it
+func dnsInZone(name string, zoneName string) bool {
+	name = strings.ToLower(name)
+	zoneName = strings.ToLower(zoneName)
+	return name == zoneName || strings.HasSuffix(name, "."+zoneName)
+}
+
+// dnsReverseName returns the name of an IP address' PTR record (e.g. 4.3.2.1.in-addr.arpa for 1.2.3.4)
+func dnsReverseName(ip net.IP) string {
+	if ip4 := ip.To4(); ip4 != nil {
+		return fmt.Sprintf("%d.%d.%d.%d.in-addr.arpa", ip4[3], ip4[2], ip4[1], ip4[0])
+	}
+	const hexDigits = "0123456789abcdef"
+	labels := make([]string, 0, 2*net.IPv6len+1)
+	for i := net.IPv6len - 1; i >= 0; i-- {
+		labels = append(labels, string(hexDigits[ip[i]&0x0f]), string(hexDigits[ip[i]>>4]))
+	}
+	return strings.Join(append(labels, "ip6.arpa"), ".")
+}
@@ -0,0 +1,144 @@
+package active_directory
+
+import (
+	"encoding/hex"
+	"net"
+	"reflect"
+	"testing"
+	"time"
+)
+
+// decodeHex decodes a hex string, failing the test if it is invalid
+func decodeHex(t *testing.T, s string) []byte {
+	t.Helper()
+	b, err := hex.DecodeString(s)
+	if err != nil {
+		t.Fatalf("invalid hex string %q: %s", s, err)
+	}
+	return b
+}
+
+func TestDecodeDnsRecord(t *testing.T) {
+	tests := []struct {
+		name  string
+		input string // Data length, type, version, rank, flags, serial, TTL, reserved, timestamp, data
+		want  DnsRecord
+	}{
+		{
+			"A dynamic",
+			"0400010005f000001e000000000004b000000000e0713800" + "c0a8010a",
+			DnsRecord{
+				Type:      DnsTypeA,
+				Ttl:       1200,
+				Serial:    30,
+				Timestamp: time.Date(2023, 1, 1, 0, 0, 0, 0, time.UTC),
+				Address:   net.IPv4(192, 168, 1, 10).To4(),
+			},
+		},
+		{
+			"AAAA static",
+			"10001c0005f000001e000000000004b00000000000000000" + "fd000000000000000000000000000010",
+			DnsRecord{Type: DnsTypeAaaa, Ttl: 1200, Serial: 30, Address: net.ParseIP("fd00::10")},
+		},
+		{
+			"CNAME",
+			"1200050005f000001e00000000000e100000000000000000" + "10030364633104636f7270056c6f63616c00",
+			DnsRecord{Type: DnsTypeCname, Ttl: 3600, Serial: 30, Target: "dc1.corp.local"},
+		},
+		{
+			"SRV",
+			"1800210005f000001e000000000002580000000000000000" + "000000640185" + "10030364633104636f7270056c6f63616c00",
+			DnsRecord{Type: DnsTypeSrv, Ttl: 600, Serial: 30, Priority: 0, Weight: 100, Port: 389, Target: "dc1.corp.local"},
+		},
+		{
+			"TXT",
+			"1000100005f000001e000000000004b00000000000000000" + "0b68656c6c6f20776f726c64" + "03666f6f",
+			DnsRecord{Type: DnsTypeTxt, Ttl: 1200, Serial: 30, Texts: []string{"hello world", "foo"}},
+		},
+		{
+			"SOA",
+			"3f00060005f000001e00000000000e100000000000000000" +
+				"0000002a" + "00000384" + "00000258" + "00015180" + "00000e10" +
+				"10030364633104636f7270056c6f63616c00" +
+				"17030a686f73746d617374657204636f7270056c6f63616c00",
+			DnsRecord{Type: DnsTypeSoa, Ttl: 3600, Serial: 30, Soa: &DnsSoa{
+				PrimaryServer: "dc1.corp.local",
+				Admin:         "hostmaster.corp.local",
+				Serial:        42,
+				Refresh:       900,
+				Retry:         600,
+				Expire:        86400,
+				MinimumTtl:    3600,
+			}},
+		},
+		{
+			"unknown type",
+			"0200630005f000001e000000000004b00000000000000000" + "abcd",
+			DnsRecord{Type: DnsRecordType(99), Ttl: 1200, Serial: 30, Raw: []byte{0xab, 0xcd}},
+		},
+		{
+			"tombstone",
+			"0000000005f000001e000000000004b00000000000000000",
+			DnsRecord{Ttl: 1200, Serial: 30, Raw: []byte{}},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := DecodeDnsRecord(decodeHex(t, tt.input))
+			if err != nil {
+				t.Fatalf("DecodeDnsRecord returned error: %s", err)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("DecodeDnsRecord = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestDecodeDnsRecordInvalid(t *testing.T) {
+	tests := []struct {
+		name  string
+		input string
+	}{
+		{"empty", ""},
+		{"truncated header", "0400010005f000001e000000000004b0"},
+		{"data length exceeds value", "6400010005f000001e000000000004b00000000000000000" + "c0a8010a"},
+		{"A wrong length", "0500010005f000001e000000000004b00000000000000000" + "c0a8010a0b"},
+		{"AAAA wrong length", "04001c0005f000001e000000000004b00000000000000000" + "c0a8010a"},
+		{"CNAME truncated name", "0400050005f000001e000000000004b00000000000000000" + "10030364"},
+		{"CNAME label exceeds name", "0400050005f000001e000000000004b00000000000000000" + "02010364"},
+		{"CNAME missing labels", "0600050005f000001e000000000004b00000000000000000" + "0403036463" + "31"},
+		{"MX truncated", "01000f0005f000001e000000000004b00000000000000000" + "00"},
+		{"SRV truncated", "0400210005f000001e000000000004b00000000000000000" + "00000064"},
+		{"TXT string exceeds data", "0400100005f000001e000000000004b00000000000000000" + "0b68656c"},
+		{"SOA truncated", "1000060005f000001e000000000004b00000000000000000" + "0000002a000003840000025800015180"},
+		{"SOA missing admin", "2600060005f000001e000000000004b00000000000000000" +
+			"0000002a000003840000025800015180" + "00000e10" + "10030364633104636f7270056c6f63616c00"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := DecodeDnsRecord(decodeHex(t, tt.input))
+			if err == nil {
+				t.Errorf("DecodeDnsRecord accepted invalid record")
+			}
+		})
+	}
+}
+
+func TestDnsReverseName(t *testing.T) {
+	tests := []struct {
+		input string
+		want  string
+	}{
+		{"192.168.1.10", "10.1.168.192.in-addr.arpa"},
+		{"::ffff:10.0.0.1", "1.0.0.10.in-addr.arpa"},
+		{"2001:db8::567:89ab", "b.a.9.8.7.6.5.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.8.b.d.0.1.0.0.2.ip6.arpa"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.input, func(t *testing.T) {
+			if got := dnsReverseName(net.ParseIP(tt.input)); got != tt.want {
+				t.Errorf("dnsReverseName(%s) = %s, want %s", tt.input, got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,66 @@
+package active_directory
+
+import (
+	"fmt"
+
+	"github.com/go-ldap/ldap/v3"
+	"github.com/siemens/GoScans/utils"
+)
+
+// ExternalOptions holds configuration for LDAP connections authenticated by a client certificate via SASL EXTERNAL.
+// The certificate given here takes precedence over a client certificate configured in the TLS options.
+type ExternalOptions struct {
+	CertFile       string // PEM client certificate
+	KeyFile        string // PEM private key of the client certificate
+	PKCS12File     string // PKCS#12 file with client certificate and private key, alternatively to PEM
+	PKCS12Password string // Optional password of the PKCS#12 file
+}
+
+// ldapConnectWithExternal establishes a TLS secured LDAP connection with a client certificate and binds with
+// SASL EXTERNAL, so the server derives the identity from the certificate
+func ldapConnectWithExternal(
+	logger utils.Logger,
+	ldapAddress string,
+	ldapOptions LdapOptions,
+) (*ldap.Conn, error) {
+	options := *ldapOptions.External
+
+	// SASL EXTERNAL requires TLS, use LDAPS if nothing else was configured
+	tlsOptions := TLSOptions{Mode: TransportLDAPS}
+	if ldapOptions.TLS != nil {
+		tlsOptions = *ldapOptions.TLS
+	}
+	if tlsOptions.Mode == TransportPlain {
+		return nil, fmt.Errorf("SASL EXTERNAL authentication requires StartTLS or LDAPS")
+	}
+
+	// Use client certificate of the external options
+	if options.CertFile != "" || options.KeyFile != "" || options.PKCS12File != "" {
+		tlsOptions.ClientCertFile = options.CertFile
+		tlsOptions.ClientKeyFile = options.KeyFile
+		tlsOptions.PKCS12File = options.PKCS12File
+		tlsOptions.PKCS12Password = options.PKCS12Password
+	}
+	if !tlsOptions.hasClientCertificate() {
+		return nil, fmt.Errorf("client certificate is required for SASL EXTERNAL authentication")
+	}
+	ldapOptions.TLS = &tlsOptions
+
+	// Open TLS connection presenting the client certificate
+	conn, err := ldapOpen(logger, ldapAddress, ldapOptions)
+	if err != nil {
+		logger.Debugf("LDAP connection to '%s:%d' failed: %s", ldapAddress, ldapOptions.port(), err)
+		return nil, err
+	}
+
+	// Bind using SASL EXTERNAL
+	err = conn.ExternalBind()
+	if err != nil {
+		conn.Close()
+		logger.Debugf("SASL EXTERNAL bind failed: %s", err)
+		return nil, fmt.Errorf("SASL EXTERNAL bind failed: %w", err)
+	}
+
+	logger.Debugf("SASL EXTERNAL bind successful")
+	return conn, nil
+}
@@ -0,0 +1,315 @@
+package active_directory
+
+import (
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+
+	"github.com/go-ldap/ldap/v3"
+	"github.com/siemens/GoScans/utils"
+)
+
+// Options of a gPLink entry, see MS-GPOL section 2.2.2
+const (
+	gpLinkDisabled = 1
+	gpLinkEnforced = 2
+)
+
+// gpOptionsBlockInheritance is the gPOptions value blocking the inheritance of GPOs linked to parent containers
+const gpOptionsBlockInheritance = 1
+
+// GpoLink is a link of a Group Policy Object to a domain, site or OU, as stored in its gPLink attribute
+type GpoLink struct {
+	Path     string `json:"path"`     // Distinguished name of the linked groupPolicyContainer
+	Order    int    `json:"order"`    // Link order, 1 takes precedence over higher numbers
+	Disabled bool   `json:"disabled"` // The link is disabled and the GPO does not apply via this link
+	Enforced bool   `json:"enforced"` // The GPO applies even if a child container blocks inheritance
+}
+
+// parseGpLink parses the value of a gPLink attribute (e.g. '[LDAP://cn={...},cn=policies,cn=system,DC=...;0]...').
+// Links are listed in reverse link order, the last entry has link order 1. They are returned by link order.
+func parseGpLink(gpLink string) ([]GpoLink, error) {
+
+	// Split entries
+	var entries []string
+	for rest := strings.TrimSpace(gpLink); rest != ""; {
+		if rest[0] != '[' {
+			return nil, fmt.Errorf("invalid gPLink '%s': expected '['", gpLink)
+		}
+		end := strings.IndexByte(rest, ']')
+		if end < 0 {
+			return nil, fmt.Errorf("invalid gPLink '%s': missing ']'", gpLink)
+		}
+		entries = append(entries, rest[1:end])
+		rest = strings.TrimSpace(rest[end+1:])
+	}
+
+	// Parse entries, starting at the last one
+	links := make([]GpoLink, 0, len(entries))
+	for i := len(entries) - 1; i >= 0; i-- {
+		separator := strings.LastIndexByte(entries[i], ';')
+		if separator < 0 {
+			return nil, fmt.Errorf("invalid gPLink entry '%s': missing options", entries[i])
+		}
+		options, errOptions := strconv.Atoi(entries[i][separator+1:])
+		if errOptions != nil {
+			return nil, fmt.Errorf("invalid gPLink entry '%s': %w", entries[i], errOptions)
+		}
+		path := entries[i][:separator]
+		if len(path) >= 7 && strings.EqualFold(path[:7], "LDAP://") {
+			path = path[7:]
+		}
+		links = append(links, GpoLink{
+			Path:     path,
+			Order:    len(links) + 1,
+			Disabled: options&gpLinkDisabled != 0,
+			Enforced: options&gpLinkEnforced != 0,
+		})
+	}
+
+	// Return links
+	return links, nil
+}
+
+// Flags of a groupPolicyContainer, see MS-GPOL section 2.3
+const (
+	GpoFlagUserDisabled     = 1
+	GpoFlagComputerDisabled = 2
+)
+
+// Gpo describes a Group Policy Object, as stored in its groupPolicyContainer
+type Gpo struct {
+	DistinguishedName string `ldap:"distinguishedName" json:"distinguishedName"`
+	Name              string `ldap:"cn" json:"name"` // GUID of the GPO in braces, e.g. {31B2F340-016D-11D2-945F-00C04FB984F9}
+	DisplayName       string `ldap:"displayName" json:"displayName"`
+	FileSysPath       string `ldap:"gPCFileSysPath" json:"fileSysPath"`
+	VersionNumber     uint32 `ldap:"versionNumber" json:"versionNumber"`
+	Flags             int    `ldap:"flags" json:"flags"`
+}
+
+// UserVersion returns the version of the GPO's user settings, stored in the upper 16 bits of versionNumber
+func (g *Gpo) UserVersion() int {
+	return int(g.VersionNumber >> 16)
+}
+
+// ComputerVersion returns the version of the GPO's computer settings, stored in the lower 16 bits of versionNumber
+func (g *Gpo) ComputerVersion() int {
+	return int(g.VersionNumber & 0xffff)
+}
+
+// UserDisabled returns whether the user settings of the GPO are disabled
+func (g *Gpo) UserDisabled() bool {
+	return g.Flags&GpoFlagUserDisabled != 0
+}
+
+// ComputerDisabled returns whether the computer settings of the GPO are disabled
+func (g *Gpo) ComputerDisabled() bool {
+	return g.Flags&GpoFlagComputerDisabled != 0
+}
+
+// AppliedGpo is a GPO applying to an object via one of its links
+type AppliedGpo struct {
+	Gpo        *Gpo    `json:"gpo"`        // Nil if the linked GPO could not be read
+	Link       GpoLink `json:"link"`       // The link the GPO applies by
+	Container  string  `json:"container"`  // Distinguished name of the site, domain or OU the GPO is linked to
+	Precedence int     `json:"precedence"` // 1 takes precedence over higher numbers
+}
+
+// EnumerateGpos lists all Group Policy Objects of the session's domain
+func EnumerateGpos(logger utils.Logger, session *LdapSession) ([]*Gpo, error) {
+
+	// Prepare search
+	logger.Debugf("LDAP enumerating GPOs in '%s'.", session.Address)
+	gpoSearch := ldap.NewSearchRequest(
+		"CN=Policies,CN=System,"+session.BaseDn(), // The base dn to search
+		ldap.ScopeSingleLevel,
+		ldap.NeverDerefAliases,
+		0,
+		0,
+		false,
+		"(objectClass=groupPolicyContainer)", // The filter to apply
+		Attributes(Gpo{}),
+		nil,
+	)
+
+	// Execute search
+	gpoResult, errSearch := session.searchPaged(logger, gpoSearch)
+	if errSearch != nil {
+		return nil, errSearch
+	}
+
+	// Decode GPOs
+	gpos := make([]*Gpo, 0, len(gpoResult.Entries))
+	for _, entry := range gpoResult.Entries {
+		gpos = append(gpos, newGpo(logger, entry))
+	}
+
+	// Return GPOs
+	return gpos, nil
+}
+
+// newGpo decodes an LDAP entry into a GPO record
+func newGpo(logger utils.Logger, entry *ldap.Entry) *Gpo {
+	gpo := &Gpo{}
+	errDecode := Unmarshal(entry, gpo)
+	if errDecode != nil {
+		logger.Errorf("Could not decode LDAP entry of GPO '%s': %s", entry.DN, errDecode)
+	}
+	return gpo
+}
+
+// AppliedGpos returns the GPOs applying to a computer by its location, ordered by precedence. Links are evaluated
+// on the computer's site (if its addresses have been resolved), domain and OUs, following the rules of Group
+// Policy processing: closer containers take precedence, unless a link is enforced. Enforced links take precedence
+// over all others, with links closer to the domain root winning. OUs blocking inheritance suppress the
+// non-enforced links of all containers above them. Disabled links and GPOs with disabled computer settings are
+// skipped. GPOs linked multiple times are returned once, with their highest precedence.
+func AppliedGpos(logger utils.Logger, session *LdapSession, computer *Ad) ([]*AppliedGpo, error) {
+
+	// Parse computer's location
+	computerDn, errDn := ParseDn(computer.DistinguishedName)
+	if errDn != nil {
+		return nil, fmt.Errorf("could not parse DN '%s': %w", computer.DistinguishedName, errDn)
+	}
+	domainDn := computerDn.DomainDn()
+
+	// Collect containers from the closest OU up to the domain
+	var containerDns []string
+	for parent := computerDn.Parent(); domainDn.IsAncestorOf(parent); parent = parent.Parent() {
+		if strings.EqualFold(parent.RdnType(), "OU") {
+			containerDns = append(containerDns, parent.String())
+		}
+	}
+	containerDns = append(containerDns, domainDn.String())
+
+	// Add the computer's site, which is the most distant container
+	siteDn, errSite := computerSite(logger, session, computer.Addresses)
+	if errSite != nil {
+		logger.Debugf("Site of '%s' could not be determined: %s", computer.DistinguishedName, errSite)
+	} else if siteDn != "" {
+		containerDns = append(containerDns, siteDn)
+	}
+
+	// Read links of each container, from the closest to the most distant
+	containers := make([]*OrganizationalUnit, 0, len(containerDns))
+	for _, containerDn := range containerDns {
+		entry, errContainer := resolveReference(logger, session, containerDn, "", Attributes(OrganizationalUnit{}))
+		if errContainer != nil {
+			return nil, fmt.Errorf("could not read '%s': %w", containerDn, errContainer)
+		}
+		if entry == nil {
+			return nil, fmt.Errorf("container '%s' not found", containerDn)
+		}
+		containers = append(containers, newOrganizationalUnit(logger, entry))
+	}
+
+	// Order the linked GPOs by precedence, reading each GPO once
+	readGpo := func(path string) *Gpo {
+		entry, errGpo := resolveReference(logger, session, path, "(objectClass=groupPolicyContainer)", Attributes(Gpo{}))
+		if errGpo != nil {
+			logger.Debugf("GPO '%s' could not be read: %s", path, errGpo)
+			return nil
+		}
+		if entry == nil {
+			return nil
+		}
+		return newGpo(logger, entry)
+	}
+	return appliedGpoLinks(containers, readGpo), nil
+}
+
+// appliedGpoLinks orders the GPO links of the given containers, from the closest to the most distant, by
+// precedence as described for AppliedGpos. The GPO of each applying link is looked up with readGpo, which
+// returns nil if it cannot be read. Such GPOs are kept, as their settings might still apply.
+func appliedGpoLinks(containers []*OrganizationalUnit, readGpo func(path string) *Gpo) []*AppliedGpo {
+
+	// Enforced links take precedence, the most distant container first
+	var applied []*AppliedGpo
+	for i := len(containers) - 1; i >= 0; i-- {
+		for _, link := range containers[i].Links {
+			if link.Enforced && !link.Disabled {
+				applied = append(applied, &AppliedGpo{Link: link, Container: containers[i].DistinguishedName})
+			}
+		}
+	}
+
+	// Other links follow, the closest container first, until a container blocks inheritance
+	for _, container := range containers {
+		for _, link := range container.Links {
+			if !link.Enforced && !link.Disabled {
+				applied = append(applied, &AppliedGpo{Link: link, Container: container.DistinguishedName})
+			}
+		}
+		if container.BlockInheritance {
+			break
+		}
+	}
+
+	// Number GPOs by precedence, skipping repeated links to the same GPO and GPOs not applying to computers
+	seen := map[string]bool{}
+	result := make([]*AppliedGpo, 0, len(applied))
+	for _, appliedGpo := range applied {
+		key := strings.ToLower(appliedGpo.Link.Path)
+		if seen[key] {
+			continue
+		}
+		seen[key] = true
+		appliedGpo.Gpo = readGpo(appliedGpo.Link.Path)
+		if appliedGpo.Gpo != nil && appliedGpo.Gpo.ComputerDisabled() {
+			continue
+		}
+		appliedGpo.Precedence = len(result) + 1
+		result = append(result, appliedGpo)
+	}
+
+	// Return GPOs by precedence
+	return result
+}
+
+// computerSite determines the site of a computer by matching its addresses against the subnets defined in the
+// configuration naming context. The most specific subnet wins. An empty DN is returned if no subnet matches.
+func computerSite(logger utils.Logger, session *LdapSession, addresses []net.IP) (string, error) {
+
+	// Check for addresses
+	if len(addresses) == 0 {
+		return "", fmt.Errorf("no addresses resolved")
+	}
+
+	// Read subnets
+	subnetSearch := ldap.NewSearchRequest(
+		"CN=Subnets,CN=Sites,"+session.ConfigurationDn(), // The base dn to search
+		ldap.ScopeSingleLevel,
+		ldap.NeverDerefAliases,
+		0,
+		0,
+		false,
+		"(objectClass=subnet)", // The filter to apply
+		[]string{"cn", "siteObject"},
+		nil,
+	)
+	subnetResult, errSearch := session.searchPaged(logger, subnetSearch)
+	if errSearch != nil {
+		return "", errSearch
+	}
+
+	// Select the most specific subnet containing any of the addresses
+	siteDn := ""
+	bestPrefix := -1
+	for _, entry := range subnetResult.Entries {
+		_, subnet, errSubnet := net.ParseCIDR(entry.GetAttributeValue("cn"))
+		if errSubnet != nil {
+			continue
+		}
+		prefix, _ := subnet.Mask.Size()
+		for _, address := range addresses {
+			if subnet.Contains(address) && prefix > bestPrefix {
+				siteDn = entry.GetAttributeValue("siteObject")
+				bestPrefix = prefix
+			}
+		}
+	}
+
+	// Return site
+	return siteDn, nil
+}
@@ -0,0 +1,196 @@
+package active_directory
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestParseGpLink(t *testing.T) {
+	tests := []struct {
+		name  string
+		input string
+		want  []GpoLink
+	}{
+		{"empty", "", []GpoLink{}},
+		{"blank", " ", []GpoLink{}},
+		{
+			"single",
+			"[LDAP://cn={A},cn=policies,cn=system,DC=corp,DC=local;0]",
+			[]GpoLink{{Path: "cn={A},cn=policies,cn=system,DC=corp,DC=local", Order: 1}},
+		},
+		{
+			"reverse link order and options",
+			"[LDAP://cn={A},cn=policies,cn=system,DC=corp,DC=local;0]" +
+				"[LDAP://cn={B},cn=policies,cn=system,DC=corp,DC=local;2] " +
+				"[ldap://cn={C},cn=policies,cn=system,DC=corp,DC=local;1]" +
+				"[LDAP://cn={D},cn=policies,cn=system,DC=corp,DC=local;3]",
+			[]GpoLink{
+				{Path: "cn={D},cn=policies,cn=system,DC=corp,DC=local", Order: 1, Disabled: true, Enforced: true},
+				{Path: "cn={C},cn=policies,cn=system,DC=corp,DC=local", Order: 2, Disabled: true},
+				{Path: "cn={B},cn=policies,cn=system,DC=corp,DC=local", Order: 3, Enforced: true},
+				{Path: "cn={A},cn=policies,cn=system,DC=corp,DC=local", Order: 4},
+			},
+		},
+		{
+			"path with semicolon",
+			"[LDAP://cn=a\\;b,DC=corp,DC=local;0]",
+			[]GpoLink{{Path: "cn=a\\;b,DC=corp,DC=local", Order: 1}},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := parseGpLink(tt.input)
+			if err != nil {
+				t.Fatalf("parseGpLink returned error: %s", err)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("parseGpLink = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestParseGpLinkInvalid(t *testing.T) {
+	tests := []struct {
+		name  string
+		input string
+	}{
+		{"missing opening bracket", "LDAP://cn={A},DC=corp,DC=local;0]"},
+		{"missing closing bracket", "[LDAP://cn={A},DC=corp,DC=local;0"},
+		{"missing options", "[LDAP://cn={A},DC=corp,DC=local]"},
+		{"invalid options", "[LDAP://cn={A},DC=corp,DC=local;x]"},
+		{"garbage between entries", "[LDAP://cn={A},DC=corp,DC=local;0]x[LDAP://cn={B},DC=corp,DC=local;0]"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := parseGpLink(tt.input); err == nil {
+				t.Errorf("parseGpLink accepted %q", tt.input)
+			}
+		})
+	}
+}
+
+// testContainer builds a container with the given links, numbered by link order
+func testContainer(dn string, blockInheritance bool, links ...GpoLink) *OrganizationalUnit {
+	for i := range links {
+		links[i].Order = i + 1
+	}
+	return &OrganizationalUnit{DistinguishedName: dn, Links: links, BlockInheritance: blockInheritance}
+}
+
+func TestAppliedGpoLinks(t *testing.T) {
+	const (
+		servers = "OU=Servers,OU=Corp,DC=corp,DC=local"
+		corp    = "OU=Corp,DC=corp,DC=local"
+		domain  = "DC=corp,DC=local"
+		site    = "CN=Site,CN=Sites,CN=Configuration,DC=corp,DC=local"
+	)
+	tests := []struct {
+		name       string
+		containers []*OrganizationalUnit // From the closest to the most distant
+		want       []string              // GPO paths by precedence, with the container they apply by
+	}{
+		{
+			"closer containers first",
+			[]*OrganizationalUnit{
+				testContainer(servers, false, GpoLink{Path: "A"}),
+				testContainer(corp, false, GpoLink{Path: "B"}),
+				testContainer(domain, false, GpoLink{Path: "C"}),
+				testContainer(site, false, GpoLink{Path: "D"}),
+			},
+			[]string{"A@" + servers, "B@" + corp, "C@" + domain, "D@" + site},
+		},
+		{
+			"link order within container",
+			[]*OrganizationalUnit{
+				testContainer(servers, false, GpoLink{Path: "A"}, GpoLink{Path: "B"}),
+				testContainer(domain, false, GpoLink{Path: "C"}),
+			},
+			[]string{"A@" + servers, "B@" + servers, "C@" + domain},
+		},
+		{
+			"enforced links first, most distant first",
+			[]*OrganizationalUnit{
+				testContainer(servers, false, GpoLink{Path: "A"}, GpoLink{Path: "B", Enforced: true}),
+				testContainer(domain, false, GpoLink{Path: "C", Enforced: true}),
+				testContainer(site, false, GpoLink{Path: "D"}),
+			},
+			[]string{"C@" + domain, "B@" + servers, "A@" + servers, "D@" + site},
+		},
+		{
+			"block inheritance",
+			[]*OrganizationalUnit{
+				testContainer(servers, false, GpoLink{Path: "A"}),
+				testContainer(corp, true, GpoLink{Path: "B"}),
+				testContainer(domain, false, GpoLink{Path: "C"}, GpoLink{Path: "D", Enforced: true}),
+				testContainer(site, false, GpoLink{Path: "E"}),
+			},
+			[]string{"D@" + domain, "A@" + servers, "B@" + corp},
+		},
+		{
+			"disabled links",
+			[]*OrganizationalUnit{
+				testContainer(servers, false, GpoLink{Path: "A", Disabled: true}, GpoLink{Path: "B"}),
+				testContainer(domain, false, GpoLink{Path: "C", Enforced: true, Disabled: true}),
+			},
+			[]string{"B@" + servers},
+		},
+		{
+			"duplicate links keep highest precedence",
+			[]*OrganizationalUnit{
+				testContainer(servers, false, GpoLink{Path: "A"}, GpoLink{Path: "B"}),
+				testContainer(domain, false, GpoLink{Path: "a"}, GpoLink{Path: "B", Enforced: true}),
+			},
+			[]string{"B@" + domain, "A@" + servers},
+		},
+		{
+			"computer settings disabled",
+			[]*OrganizationalUnit{
+				testContainer(servers, false, GpoLink{Path: "A"}, GpoLink{Path: "UserOnly"}, GpoLink{Path: "B"}),
+				testContainer(domain, false, GpoLink{Path: "Disabled"}, GpoLink{Path: "C"}),
+			},
+			[]string{"A@" + servers, "B@" + servers, "C@" + domain},
+		},
+		{
+			"unreadable GPOs are kept",
+			[]*OrganizationalUnit{
+				testContainer(servers, false, GpoLink{Path: "Unreadable"}, GpoLink{Path: "A"}),
+			},
+			[]string{"Unreadable@" + servers, "A@" + servers},
+		},
+	}
+
+	// GPOs by path, missing ones cannot be read
+	gpos := map[string]*Gpo{
+		"a":        {Name: "A"},
+		"b":        {Name: "B"},
+		"c":        {Name: "C"},
+		"d":        {Name: "D"},
+		"e":        {Name: "E"},
+		"useronly": {Name: "UserOnly", Flags: GpoFlagComputerDisabled},
+		"disabled": {Name: "Disabled", Flags: GpoFlagUserDisabled | GpoFlagComputerDisabled},
+	}
+	readGpo := func(path string) *Gpo {
+		return gpos[strings.ToLower(path)]
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			applied := appliedGpoLinks(tt.containers, readGpo)
+			got := make([]string, 0, len(applied))
+			for i, appliedGpo := range applied {
+				got = append(got, appliedGpo.Link.Path+"@"+appliedGpo.Container)
+				if appliedGpo.Precedence != i+1 {
+					t.Errorf("GPO '%s' has precedence %d, want %d", appliedGpo.Link.Path, appliedGpo.Precedence, i+1)
+				}
+				if want := gpos[strings.ToLower(appliedGpo.Link.Path)]; appliedGpo.Gpo != want {
+					t.Errorf("GPO '%s' has record %+v, want %+v", appliedGpo.Link.Path, appliedGpo.Gpo, want)
+				}
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("appliedGpoLinks = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,267 @@
+package active_directory
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/go-ldap/ldap/v3"
+	"github.com/siemens/GoScans/utils"
+)
+
+// matchingRuleInChain is the OID of LDAP_MATCHING_RULE_IN_CHAIN, which lets the server evaluate DN-valued
+// attributes transitively (e.g. nested group memberships)
+const matchingRuleInChain = "1.2.840.113556.1.4.1941"
+
+// groupFilter matches security and distribution groups
+const groupFilter = "(objectClass=group)"
+
+// Group describes a security or distribution group
+type Group struct {
+	DistinguishedName string   `ldap:"distinguishedName"`
+	ObjectSid         Sid      `ldap:"objectSid"`
+	ObjectGuid        Guid     `ldap:"objectGUID"`
+	SamAccountName    string   `ldap:"sAMAccountName"`
+	Cn                string   `ldap:"cn"`
+	Description       []string `ldap:"description"`
+	Mail              string   `ldap:"mail"`
+	ManagedBy         string   `ldap:"managedBy"`
+	GroupType         int32    `ldap:"groupType"` // Scope and type flags, negative for security groups
+	Member            []string `ldap:"member"`    // Direct members, read completely even if exceeding MaxValRange
+	MemberOf          []string `ldap:"memberOf"`  // Direct memberships
+}
+
+// GroupMember describes a direct or nested member of a group
+type GroupMember struct {
+	Kind              OwnerKind
+	DistinguishedName string `ldap:"distinguishedName"`
+	ObjectSid         Sid    `ldap:"objectSid"` // For foreign security principals, the SID of the object in the other forest
+	SamAccountName    string `ldap:"sAMAccountName"`
+}
+
+// groupMemberAttributes lists the attributes to request for group members
+var groupMemberAttributes = append(Attributes(GroupMember{}), "objectClass")
+
+// newGroupMember decodes an LDAP entry into a group member record
+func newGroupMember(logger utils.Logger, entry *ldap.Entry) *GroupMember {
+	member := &GroupMember{
+		Kind: ownerKind(entry.GetAttributeValues("objectClass")),
+	}
+	errDecode := Unmarshal(entry, member)
+	if errDecode != nil {
+		logger.Errorf("Could not decode LDAP entry of group member '%s': %s", entry.DN, errDecode)
+	}
+	return member
+}
+
+// newGroup decodes an LDAP entry into a group record, reading all members if the server returned them in ranges
+func newGroup(logger utils.Logger, session *LdapSession, entry *ldap.Entry) *Group {
+	group := &Group{}
+	errDecode := session.Unmarshal(logger, entry, group)
+	if errDecode != nil {
+		logger.Errorf("Could not decode LDAP entry of group '%s': %s", entry.DN, errDecode)
+	}
+	return group
+}
+
+// LookupGroup searches the group with the given sAMAccountName within the session's domain. Nil is returned if no
+// such group exists.
+func LookupGroup(logger utils.Logger, session *LdapSession, name string) (*Group, error) {
+
+	// Prepare search
+	logger.Debugf("LDAP searching for group '%s' in '%s'.", name, session.Address)
+	groupSearch := ldap.NewSearchRequest(
+		session.BaseDn(), // The base dn to search
+		ldap.ScopeWholeSubtree,
+		ldap.NeverDerefAliases,
+		0,
+		0,
+		false,
+		fmt.Sprintf("(&%s(sAMAccountName=%s))", groupFilter, ldap.EscapeFilter(name)), // The filter to apply
+		Attributes(Group{}),
+		nil,
+	)
+
+	// Execute search
+	groupResult, errSearch := session.search(logger, groupSearch)
+	if errSearch != nil {
+		return nil, errSearch
+	}
+
+	// Check for result
+	if len(groupResult.Entries) == 0 {
+		logger.Debugf("LDAP search for group '%s' in '%s' did not return result.", name, session.Address)
+		return nil, nil
+	} else if len(groupResult.Entries) > 1 {
+		return nil, fmt.Errorf("search for group '%s' returned ambiguous results", name)
+	}
+
+	// Return decoded group
+	return newGroup(logger, session, groupResult.Entries[0]), nil
+}
+
+// EnumerateGroups lists all groups of the session's domain matching the given additional filter, or all groups if
+// the filter is empty. Results are retrieved in pages, so they are not limited by the server's size limit.
+func EnumerateGroups(logger utils.Logger, session *LdapSession, filter string) ([]*Group, error) {
+
+	// Prepare search
+	logger.Debugf("LDAP enumerating groups in '%s'.", session.Address)
+	groupSearch := ldap.NewSearchRequest(
+		session.BaseDn(), // The base dn to search
+		ldap.ScopeWholeSubtree,
+		ldap.NeverDerefAliases,
+		0,
+		0,
+		false,
+		fmt.Sprintf("(&%s%s)", groupFilter, filter), // The filter to apply
+		Attributes(Group{}),
+		nil,
+	)
+
+	// Execute search
+	groupResult, errSearch := session.searchPaged(logger, groupSearch)
+	if errSearch != nil {
+		return nil, errSearch
+	}
+
+	// Decode groups
+	groups := make([]*Group, 0, len(groupResult.Entries))
+	for _, entry := range groupResult.Entries {
+		groups = append(groups, newGroup(logger, session, entry))
+	}
+
+	// Return groups
+	return groups, nil
+}
+
+// TransitiveMembers returns all direct and nested members of the group with the given DN, including foreign
+// security principals representing members of other forests. Members via primaryGroupID are not included, as
+// Active Directory does not list them in the member attribute. The server evaluates nesting via
+// LDAP_MATCHING_RULE_IN_CHAIN, if this fails the groups are walked client-side.
+func TransitiveMembers(logger utils.Logger, session *LdapSession, groupDn string) ([]*GroupMember, error) {
+
+	// Let the server resolve nested memberships
+	filter := fmt.Sprintf("(memberOf:%s:=%s)", matchingRuleInChain, ldap.EscapeFilter(groupDn))
+	members, errChain := ldapTransitiveSearch(logger, session, filter)
+	if errChain == nil {
+		return members, nil
+	}
+	logger.Debugf("LDAP in-chain search for members of '%s' failed, resolving recursively: %s", groupDn, errChain)
+
+	// Walk the groups client-side
+	return ldapTransitiveWalk(logger, session, groupDn, "member")
+}
+
+// TransitiveGroups returns all groups the object with the given DN is a direct or nested member of. The primary
+// group is not included, as Active Directory does not list it in the memberOf attribute. The server evaluates
+// nesting via LDAP_MATCHING_RULE_IN_CHAIN, if this fails the groups are walked client-side.
+func TransitiveGroups(logger utils.Logger, session *LdapSession, dn string) ([]*GroupMember, error) {
+
+	// Let the server resolve nested memberships
+	filter := fmt.Sprintf("(&%s(member:%s:=%s))", groupFilter, matchingRuleInChain, ldap.EscapeFilter(dn))
+	groups, errChain := ldapTransitiveSearch(logger, session, filter)
+	if errChain == nil {
+		return groups, nil
+	}
+	logger.Debugf("LDAP in-chain search for groups of '%s' failed, resolving recursively: %s", dn, errChain)
+
+	// Walk the groups client-side
+	return ldapTransitiveWalk(logger, session, dn, "memberOf")
+}
+
+// ldapTransitiveSearch searches the session's domain for objects matching an in-chain filter
+func ldapTransitiveSearch(logger utils.Logger, session *LdapSession, filter string) ([]*GroupMember, error) {
+
+	// Prepare search
+	chainSearch := ldap.NewSearchRequest(
+		session.BaseDn(), // The base dn to search
+		ldap.ScopeWholeSubtree,
+		ldap.NeverDerefAliases,
+		0,
+		0,
+		false,
+		filter, // The filter to apply
+		groupMemberAttributes,
+		nil,
+	)
+
+	// Execute search
+	chainResult, errSearch := session.searchPaged(logger, chainSearch)
+	if errSearch != nil {
+		return nil, errSearch
+	}
+
+	// Decode results
+	results := make([]*GroupMember, 0, len(chainResult.Entries))
+	for _, entry := range chainResult.Entries {
+		results = append(results, newGroupMember(logger, entry))
+	}
+
+	// Return results
+	return results, nil
+}
+
+// ldapTransitiveWalk follows the given DN-valued attribute (member or memberOf) recursively, starting at the object
+// with the given DN. Every object is visited once, so cyclic nesting terminates. Only groups are followed, other
+// objects (e.g. foreign security principals) are returned as they are. Objects that cannot be read are skipped.
+func ldapTransitiveWalk(
+	logger utils.Logger,
+	session *LdapSession,
+	dn string,
+	attribute string,
+) ([]*GroupMember, error) {
+
+	// Read start object
+	attributes := append(append([]string{}, groupMemberAttributes...), attribute)
+	start, startSession, errStart := resolveReferenceWithSession(logger, session, dn, "", attributes)
+	if errStart != nil {
+		return nil, errStart
+	}
+	if start == nil {
+		return nil, fmt.Errorf("object '%s' not found", dn)
+	}
+
+	// Walk references breadth first, remembering the session each object was read with
+	type walkEntry struct {
+		entry   *ldap.Entry
+		session *LdapSession
+	}
+	visited := map[string]bool{strings.ToLower(start.DN): true}
+	queue := []walkEntry{{start, startSession}}
+	var results []*GroupMember
+	for len(queue) > 0 {
+		entry := queue[0].entry
+		entrySession := queue[0].session
+		queue = queue[1:]
+
+		// Read all references, even if returned in ranges, from the domain the object was read from
+		references, errReferences := ldapRangedStrings(logger, entrySession, entry, attribute)
+		if errReferences != nil {
+			logger.Warningf("LDAP '%s' of '%s' could not be read completely: %s", attribute, entry.DN, errReferences)
+		}
+
+		// Visit each referenced object once
+		for _, reference := range references {
+			if visited[strings.ToLower(reference)] {
+				continue
+			}
+			visited[strings.ToLower(reference)] = true
+			referenced, referencedSession, errReference := resolveReferenceWithSession(
+				logger, session, reference, "", attributes)
+			if errReference != nil {
+				logger.Debugf("LDAP reference '%s' could not be resolved: %s", reference, errReference)
+				continue
+			}
+			if referenced == nil {
+				continue
+			}
+			member := newGroupMember(logger, referenced)
+			results = append(results, member)
+			if member.Kind == OwnerKindGroup {
+				queue = append(queue, walkEntry{referenced, referencedSession})
+			}
+		}
+	}
+
+	// Return results
+	return results, nil
+}
@@ -2,7 +2,6 @@ package active_directory
 
 import (
 	"fmt"
-	"net"
 	"strings"
 	"time"
 
@@ -20,33 +19,35 @@ type GSSAPIOptions struct {
 	ConfigFilePath       string // Optional Config file
 	Realms               []config.Realm
 	ServicePrincipalName string
+	SecurityLayer        SaslSecurityLayer // Optional protection of LDAP messages on plain connections, e.g. for DCs requiring LDAP signing
 }
 
 // ldapConnectWithGSSAPI establishes an LDAP connection with GSSAPI (Kerberos) authentication
 func ldapConnectWithGSSAPI(
 	logger utils.Logger,
 	ldapAddress string,
-	ldapPort int,
-	ldapUser string,
-	ldapPassword string,
-	dialTimeout time.Duration,
-	options GSSAPIOptions,
+	ldapOptions LdapOptions,
 ) (*ldap.Conn, error) {
+	options := *ldapOptions.GSSAPI
+	ldapUser := ldapOptions.User
+	ldapPassword := ldapOptions.Password
+
 	// Validate required options
 	if options.DefaultRealm == "" {
 		return nil, fmt.Errorf("Kerberos realm is required for GSSAPI authentication")
 	}
 
-	// Sanitize LDAP address
-	baseUrl := strings.TrimPrefix(ldapAddress, "ldap://")
-	baseUrl = strings.TrimPrefix(baseUrl, "ldaps://")
-	baseUrl = strings.TrimPrefix(baseUrl, "ldapi://")
-
-	// Open a standard LDAP connection
-	conn, err := ldap.DialURL(fmt.Sprintf("ldap://%s:%d", baseUrl, ldapPort),
-		ldap.DialWithDialer(&net.Dialer{Timeout: dialTimeout}))
+	// Open LDAP connection with the configured transport, prepared for a security layer if requested
+	var conn *ldap.Conn
+	var sc *saslConn
+	var err error
+	if options.SecurityLayer != SaslSecurityLayerNone {
+		conn, sc, err = ldapOpenSasl(logger, ldapAddress, ldapOptions)
+	} else {
+		conn, err = ldapOpen(logger, ldapAddress, ldapOptions)
+	}
 	if err != nil {
-		logger.Debugf("LDAP connection to '%s:%d' failed: %s", ldapAddress, ldapPort, err)
+		logger.Debugf("LDAP connection to '%s:%d' failed: %s", ldapAddress, ldapOptions.port(), err)
 		return nil, err
 	}
 
@@ -93,8 +94,16 @@ func ldapConnectWithGSSAPI(
 		}
 	}
 
+	// Negotiate the requested security layer, if any
+	var bindClient ldap.GSSAPIClient = gssapiClient
+	var layerClient *gssapiLayerClient
+	if sc != nil {
+		layerClient = &gssapiLayerClient{krbClient: gssapiClient.Client, layer: options.SecurityLayer}
+		bindClient = layerClient
+	}
+
 	// Bind using GSSAPI with mutual authentication
-	err = conn.GSSAPIBindRequestWithAPOptions(gssapiClient, &ldap.GSSAPIBindRequest{
+	err = conn.GSSAPIBindRequestWithAPOptions(bindClient, &ldap.GSSAPIBindRequest{
 		ServicePrincipalName: fmt.Sprintf("ldap/%s", options.ServicePrincipalName),
 		AuthZID:              "",
 	}, []int{flags.APOptionMutualRequired})
@@ -105,6 +114,12 @@ func ldapConnectWithGSSAPI(
 		return nil, fmt.Errorf("GSSAPI bind failed: %w", err)
 	}
 
+	// Protect all subsequent LDAP messages
+	if sc != nil {
+		sc.enable(layerClient.context)
+		logger.Debugf("SASL security layer '%s' established", options.SecurityLayer)
+	}
+
 	logger.Debugf("GSSAPI bind successful to %s", fmt.Sprintf("ldap/%s", options.ServicePrincipalName))
 	return conn, nil
 }
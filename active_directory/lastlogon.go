@@ -0,0 +1,158 @@
+package active_directory
+
+import (
+	"fmt"
+	"net"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/go-ldap/ldap/v3"
+	"github.com/siemens/GoScans/utils"
+)
+
+// matchingRuleBitAnd is the OID of LDAP_MATCHING_RULE_BIT_AND, which matches integer attributes having all bits
+// of the given value set
+const matchingRuleBitAnd = "1.2.840.113556.1.4.803"
+
+// domainControllers returns the DNS host names of all domain controllers of the session's domain. They are taken
+// from the computer accounts marked as SERVER_TRUST_ACCOUNT, wherever they are located within the domain, or from
+// the domain's DC SRV records, if none can be read.
+func domainControllers(logger utils.Logger, session *LdapSession) ([]string, error) {
+
+	// Search domain controller accounts within the whole domain
+	baseDn := session.BaseDn()
+	dcSearch := ldap.NewSearchRequest(
+		baseDn, // The base dn to search
+		ldap.ScopeWholeSubtree,
+		ldap.NeverDerefAliases,
+		0,
+		0,
+		false,
+		fmt.Sprintf("(userAccountControl:%s:=%d)", matchingRuleBitAnd, UacServerTrustAccount), // The filter to apply
+		[]string{"dNSHostName"},
+		nil,
+	)
+	dcResult, errSearch := session.searchPaged(logger, dcSearch)
+	if errSearch != nil {
+		logger.Debugf("LDAP search for domain controllers in '%s' failed: %s", baseDn, errSearch)
+	}
+
+	// Collect host names
+	var hosts []string
+	if dcResult != nil {
+		for _, entry := range dcResult.Entries {
+			if host := entry.GetAttributeValue("dNSHostName"); host != "" {
+				hosts = append(hosts, host)
+			}
+		}
+	}
+	if len(hosts) > 0 {
+		return hosts, nil
+	}
+
+	// Fall back to the domain's DC SRV records
+	dn, errDn := ParseDn(baseDn)
+	if errDn != nil {
+		return nil, fmt.Errorf("could not parse base DN '%s': %w", baseDn, errDn)
+	}
+	_, records, errSrv := net.LookupSRV("ldap", "tcp", "dc._msdcs."+dn.Domain())
+	if errSrv != nil {
+		return nil, fmt.Errorf("could not discover domain controllers of '%s': %w", dn.Domain(), errSrv)
+	}
+	for _, record := range records {
+		hosts = append(hosts, strings.TrimSuffix(record.Target, "."))
+	}
+
+	// Return host names
+	return hosts, nil
+}
+
+// ldapLastLogons reads the non-replicated lastLogon attribute of the object with the given DN from every domain
+// controller of the session's domain concurrently. Domain controllers that cannot be queried are logged and
+// skipped. Values are returned by DC host name, unset values as zero time.
+func ldapLastLogons(logger utils.Logger, session *LdapSession, dn string) (map[string]time.Time, error) {
+
+	// Discover domain controllers
+	hosts, errHosts := domainControllers(logger, session)
+	if errHosts != nil {
+		return nil, errHosts
+	}
+
+	// Query each domain controller concurrently
+	var wg sync.WaitGroup
+	var mutex sync.Mutex
+	lastLogons := make(map[string]time.Time, len(hosts))
+	for _, host := range hosts {
+		wg.Add(1)
+		go func(host string) {
+			defer wg.Done()
+			lastLogon, errLastLogon := ldapLastLogon(logger, host, session.Options, dn)
+			if errLastLogon != nil {
+				logger.Debugf("LDAP lastLogon of '%s' could not be read from '%s': %s", dn, host, errLastLogon)
+				return
+			}
+			mutex.Lock()
+			lastLogons[host] = lastLogon
+			mutex.Unlock()
+		}(host)
+	}
+	wg.Wait()
+
+	// Check for results
+	if len(lastLogons) == 0 {
+		return nil, fmt.Errorf("none of %d domain controllers returned lastLogon", len(hosts))
+	}
+
+	// Return values by domain controller
+	return lastLogons, nil
+}
+
+// ldapLastLogon reads the lastLogon attribute of the object with the given DN from a single domain controller
+func ldapLastLogon(logger utils.Logger, host string, options LdapOptions, dn string) (time.Time, error) {
+
+	// Connect to the domain controller with the same settings, but authenticating it by its own name
+	session, errConn := LdapConnect(logger, host, options.forHost(host))
+	if errConn != nil {
+		return time.Time{}, errConn
+	}
+	defer session.Close()
+
+	// Read attribute of the object
+	lastLogonSearch := ldap.NewSearchRequest(
+		dn, // The object to read
+		ldap.ScopeBaseObject,
+		ldap.NeverDerefAliases,
+		0,
+		0,
+		false,
+		"(objectClass=*)", // The filter to apply
+		[]string{"lastLogon"},
+		nil,
+	)
+	lastLogonResult, errSearch := session.search(logger, lastLogonSearch)
+	if errSearch != nil {
+		return time.Time{}, errSearch
+	}
+	if len(lastLogonResult.Entries) == 0 {
+		return time.Time{}, fmt.Errorf("object not found")
+	}
+
+	// Decode value, a missing attribute means the object never logged on at this domain controller
+	val := lastLogonResult.Entries[0].GetAttributeValue("lastLogon")
+	if val == "" {
+		return time.Time{}, nil
+	}
+	return decodeTime(val)
+}
+
+// latestLogon returns the most recent of the given logon times
+func latestLogon(lastLogons map[string]time.Time) time.Time {
+	var latest time.Time
+	for _, lastLogon := range lastLogons {
+		if lastLogon.After(latest) {
+			latest = lastLogon
+		}
+	}
+	return latest
+}
@@ -4,302 +4,329 @@ import (
 	"fmt"
 	"github.com/go-ldap/ldap/v3"
 	"github.com/siemens/GoScans/utils"
-	"net"
-	"strconv"
-	"strings"
 	"time"
 )
 
+// LdapOptions holds connection and authentication settings for LDAP queries
+type LdapOptions struct {
+	Port        int // Optional, defaults to 389 or 636, depending on the transport mode
+	User        string
+	Password    string
+	DialTimeout time.Duration
+	GSSAPI      *GSSAPIOptions    // nil for standard auth, non-nil for GSSAPI
+	NTLM        *NTLMOptions      // nil for standard auth, non-nil for NTLM
+	External    *ExternalOptions  // nil for standard auth, non-nil for SASL EXTERNAL with a client certificate
+	TLS         *TLSOptions       // nil for plain LDAP
+	Retry       *RetryOptions     // nil to disable retries of transient errors
+	Attributes  *AttributeProfile // nil for the default Active Directory schema
+	Resolve     *ResolveOptions   // nil to skip resolving the computer's DNS host name to addresses
+
+	RequireAuthenticated bool // Fail if credentials were given, but the bind identity is anonymous or cannot be verified
+	ExpandOwnerMembers   bool // Resolve the direct members of groups referenced by managedBy
+	AccurateLastLogon    bool // Read the non-replicated lastLogon from every DC of the domain and take the latest
+}
+
+// hasCredentials returns whether the options request an authenticated bind
+func (o LdapOptions) hasCredentials() bool {
+	return o.User != "" || o.GSSAPI != nil || o.NTLM != nil || o.External != nil
+}
+
+// port returns the configured port, or the default port of the configured transport mode. If SASL EXTERNAL is the
+// selected authentication method, missing TLS options default to LDAPS, as done by ldapConnectWithExternal.
+func (o LdapOptions) port() int {
+	if o.Port > 0 {
+		return o.Port
+	}
+	if o.TLS != nil && o.TLS.Mode == TransportLDAPS {
+		return defaultLdapsPort
+	}
+	if o.TLS == nil && o.External != nil && o.GSSAPI == nil && o.NTLM == nil {
+		return defaultLdapsPort
+	}
+	return defaultLdapPort
+}
+
+// forHost returns a copy of the options for connecting to another server of the same domain. The Kerberos service
+// principal and the name to verify the server certificate against are set to the given host, as the configured
+// ones refer to the original server.
+func (o LdapOptions) forHost(host string) LdapOptions {
+	if o.GSSAPI != nil {
+		gssapiOptions := *o.GSSAPI
+		gssapiOptions.ServicePrincipalName = host
+		o.GSSAPI = &gssapiOptions
+	}
+	if o.TLS != nil {
+		tlsOptions := *o.TLS
+		tlsOptions.ServerName = host
+		o.TLS = &tlsOptions
+	}
+	return o
+}
+
 // LdapQuery queries the given Active Directory service with explicit authentication and returns a pointer to
-// a populated Ad struct.
+// a populated Ad struct. The attributes to request are derived from the Ad struct's ldap tags, unless they are
+// given explicitly.
 // ATTENTION: Make sure searchCn / ldapAddress are sanitized if taken from user input, to avoid SQL injection attacks!
 func LdapQuery(
 	logger utils.Logger,
 	searchCn string,
 	ldapAddress string,
-	ldapPort int,
-	ldapUser string,
-	ldapPassword string,
-	dialTimeout time.Duration,
-	gssapiOptions *GSSAPIOptions, // nil for standard auth, non-nil for GSSAPI
+	options LdapOptions,
+	attributes ...string, // Optional attributes to request instead of the ones defined by the Ad struct
 ) *Ad {
+	filter := fmt.Sprintf("(&(objectClass=computer)(cn=%s))", searchCn)
+	return ldapQuery(logger, searchCn, "", ldap.ScopeWholeSubtree, filter, ldapAddress, options, attributes)
+}
 
-	logger.Debugf("Searching LDAP with explicit authentication for '%s'.", searchCn)
+// LdapQueryBySid works like LdapQuery, but looks up the computer by its objectSid, which remains stable across
+// renames. The object is read directly via a <SID=...> base DN.
+func LdapQueryBySid(
+	logger utils.Logger,
+	sid Sid,
+	ldapAddress string,
+	options LdapOptions,
+	attributes ...string, // Optional attributes to request instead of the ones defined by the Ad struct
+) *Ad {
+	if !sid.valid() {
+		logger.Debugf("Invalid SID '%s'.", sid)
+		return &Ad{}
+	}
+	searchBase := fmt.Sprintf("<SID=%s>", sid)
+	return ldapQuery(
+		logger, string(sid), searchBase, ldap.ScopeBaseObject, "(objectClass=computer)", ldapAddress, options, attributes)
+}
 
-	var conn *ldap.Conn
-	var errConn error
+// LdapQueryByGuid works like LdapQuery, but looks up the computer by its objectGUID, which remains stable across
+// renames and moves. The object is read directly via a <GUID=...> base DN.
+func LdapQueryByGuid(
+	logger utils.Logger,
+	guid Guid,
+	ldapAddress string,
+	options LdapOptions,
+	attributes ...string, // Optional attributes to request instead of the ones defined by the Ad struct
+) *Ad {
+	if !guid.valid() {
+		logger.Debugf("Invalid GUID '%s'.", guid)
+		return &Ad{}
+	}
+	searchBase := fmt.Sprintf("<GUID=%s>", guid)
+	return ldapQuery(
+		logger, string(guid), searchBase, ldap.ScopeBaseObject, "(objectClass=computer)", ldapAddress, options, attributes)
+}
+
+// ldapQuery searches a single computer with the given search base, scope and filter and returns a pointer to a
+// populated Ad struct. An empty search base selects the domain's base DN.
+func ldapQuery(
+	logger utils.Logger,
+	searchName string,
+	searchBase string,
+	searchScope int,
+	searchFilter string,
+	ldapAddress string,
+	options LdapOptions,
+	attributes []string,
+) *Ad {
+
+	logger.Debugf("Searching LDAP with explicit authentication for '%s'.", searchName)
 
 	// Connect to LDAP with appropriate authentication method
-	if gssapiOptions != nil {
-		// Connect with GSSAPI
-		conn, errConn = ldapConnectWithGSSAPI(logger, ldapAddress, ldapPort, ldapUser, ldapPassword, dialTimeout, *gssapiOptions)
-	} else {
-		// Connect with standard LDAP authentication
-		conn, errConn = ldapConnect(logger, ldapAddress, ldapPort, ldapUser, ldapPassword, dialTimeout)
-	}
+	session, errConn := LdapConnect(logger, ldapAddress, options)
 	if errConn != nil {
-		logger.Debugf("LDAP connection to '%s:%d' failed: %s", ldapAddress, ldapPort, errConn)
+		logger.Debugf("LDAP connection to '%s:%d' failed: %s", ldapAddress, options.port(), errConn)
 		return &Ad{}
 	} else {
-		logger.Debugf("LDAP connection to '%s:%d' succeeded.", ldapAddress, ldapPort)
+		logger.Debugf("LDAP connection to '%s:%d' succeeded.", ldapAddress, options.port())
 	}
 
 	// Make sure connection is closed on exit
-	defer conn.Close()
+	defer session.Close()
+
+	// Search computer
+	return ldapQuerySession(logger, session, searchName, searchBase, searchScope, searchFilter, attributes)
+}
 
-	// Convert domain name into distinguished name
-	baseDn := fqdnToDn(ldapAddress)
+// ldapQuerySession works like ldapQuery, but searches on an established session
+func ldapQuerySession(
+	logger utils.Logger,
+	session *LdapSession,
+	searchName string,
+	searchBase string,
+	searchScope int,
+	searchFilter string,
+	attributes []string,
+) *Ad {
+
+	// Take domain's distinguished name from the server, unless the object is addressed directly
+	if searchBase == "" {
+		searchBase = session.BaseDn()
+	}
+
+	// Prepare attributes to request, including the deployment specific ones
+	profile := profileOrDefault(session.Options.Attributes)
+	if len(attributes) == 0 {
+		attributes = Attributes(Ad{})
+	}
+	attributes = append(append([]string{}, attributes...), profile.ComputerAttributes...)
 
 	// Prepare search
-	logger.Debugf("LDAP searching for computer '%s' in '%s'.", searchCn, ldapAddress)
+	logger.Debugf("LDAP searching for computer '%s' in '%s'.", searchName, session.Address)
 	computerSearch := ldap.NewSearchRequest(
-		baseDn, // The base dn to search
-		ldap.ScopeWholeSubtree,
+		searchBase, // The base dn to search
+		searchScope,
 		ldap.NeverDerefAliases,
 		0,
 		0,
 		false,
-		fmt.Sprintf("(&(objectClass=computer)(cn=%s))", searchCn), // The filter to apply
-		[]string{
-			"name", "distinguishedName", "dNSHostName", "description", "whenCreated", "managedBy", "lastLogon",
-			"pwdLastSet", "location", "operatingSystem", "operatingSystemVersion",
-			"servicePrincipalName", "isCriticalSystemObject",
-		},
+		searchFilter, // The filter to apply
+		attributes,
 		nil,
 	)
 
-	// Execute search
-	computerResult, errComputerSearch := conn.Search(computerSearch)
-	if errComputerSearch != nil {
-		logger.Debugf("LDAP search for computer '%s' in '%s' failed: %s", searchCn, ldapAddress, errComputerSearch)
+	// Execute search, a missing object addressed by SID or GUID is no error
+	computerResult, errComputerSearch := session.search(logger, computerSearch)
+	if ldap.IsErrorWithCode(errComputerSearch, ldap.LDAPResultNoSuchObject) {
+		logger.Debugf("LDAP search for computer '%s' in '%s' did not return result.", searchName, session.Address)
+		return &Ad{}
+	} else if errComputerSearch != nil {
+		logger.Debugf("LDAP search for computer '%s' in '%s' failed: %s", searchName, session.Address, errComputerSearch)
 		return &Ad{}
 	}
 
 	// Check for result
 	if len(computerResult.Entries) == 0 {
-		logger.Debugf("LDAP search for computer '%s' in '%s' did not return result.", searchCn, ldapAddress)
+		logger.Debugf("LDAP search for computer '%s' in '%s' did not return result.", searchName, session.Address)
 		return &Ad{}
 	} else if len(computerResult.Entries) > 1 {
-		logger.Warningf("LDAP search for computer '%s' in '%s' returned ambiguous results.", searchCn, ldapAddress)
+		logger.Warningf("LDAP search for computer '%s' in '%s' returned ambiguous results.", searchName, session.Address)
 		return &Ad{}
 	}
 
-	// Prepare result variables
-	var created time.Time
-	var lastLogon time.Time
-	var lastPassword time.Time
-	var criticalObject bool
-
 	// Take first result
 	entry := computerResult.Entries[0]
 
-	// Parse special values
-	var err error
-	val := entry.GetAttributeValue("lastLogon")
-	if len(val) > 0 {
-
-		// Parse int64 from string and convert to time.time
-		lastLogonInt8, errLastLogon := strconv.ParseInt(val, 10, 64)
-		if errLastLogon != nil {
-			logger.Errorf("Could not parse Integer8 '%s': %s", val, errLastLogon)
-		} else {
-			lastLogon = Integer8ToTime(lastLogonInt8)
-		}
+	// Decode entry into result struct
+	result := Ad{}
+	errDecode := session.Unmarshal(logger, entry, &result)
+	if errDecode != nil {
+		logger.Errorf("Could not decode LDAP entry of computer '%s': %s", searchName, errDecode)
 	}
-	val = entry.GetAttributeValue("pwdLastSet")
-	if len(val) > 0 {
+	result.Extra = extraAttributes(entry, profile.ComputerAttributes)
 
-		// Parse int64 from string and convert to time.time
-		pwdLastSetInt8, errPwdLastSet := strconv.ParseInt(val, 10, 64)
-		if errPwdLastSet != nil {
-			logger.Errorf("Could not parse Integer8 '%s': %s", val, errPwdLastSet)
+	// Read lastLogon from all domain controllers, as it is not replicated
+	if session.Options.AccurateLastLogon && result.DistinguishedName != "" {
+		lastLogons, errLastLogons := ldapLastLogons(logger, session, result.DistinguishedName)
+		if errLastLogons != nil {
+			logger.Warningf("LDAP lastLogon of '%s' could not be collected: %s", searchName, errLastLogons)
 		} else {
-			lastPassword = Integer8ToTime(pwdLastSetInt8)
+			result.LastLogonPerDc = lastLogons
+			if latest := latestLogon(lastLogons); latest.After(result.LastLogon) {
+				result.LastLogon = latest
+			}
 		}
 	}
-	val = entry.GetAttributeValue("whenCreated")
-	if len(val) > 0 {
-		created, err = GeneralizedTimeToTime(val)
-		if err != nil {
-			logger.Errorf(
-				"Could not parse GeneralizedTime '%s': %s", val, err)
-		}
-	}
-	val = entry.GetAttributeValue("isCriticalSystemObject")
-	if len(val) > 0 {
-		criticalObject, err = strconv.ParseBool(val)
-		if err != nil {
-			logger.Errorf(
-				"Could not parse boolean '%s': %s", val, err)
-		}
+
+	// Resolve DNS host name to addresses
+	if session.Options.Resolve != nil {
+		ResolveAddresses(logger, &result, session.Options.Resolve)
 	}
 
 	// Read managedBy attribute, which will be used later to query user object
-	managedBy := entry.GetAttributeValue("managedBy")
-
-	// Prepare first result struct
-	result := Ad{
-		Name:                 entry.GetAttributeValue("name"),
-		DistinguishedName:    entry.GetAttributeValue("distinguishedName"),
-		DnsName:              entry.GetAttributeValue("dNSHostName"),
-		Created:              created,
-		LastLogon:            lastLogon,
-		LastPassword:         lastPassword,
-		Description:          entry.GetAttributeValues("description"),
-		Location:             entry.GetAttributeValue("location"),
-		ManagedBy:            managedBy,
-		Os:                   entry.GetAttributeValue("operatingSystem"),
-		OsVersion:            entry.GetAttributeValue("operatingSystemVersion"),
-		ServicePrincipalName: entry.GetAttributeValues("servicePrincipalName"),
-		CriticalObject:       criticalObject,
-	}
+	managedBy := result.ManagedBy
 
 	// Execute user query, if managedBy is set
 	if len(managedBy) > 6 { // > 6 because there must be 'CN=' and 'DC=' at least
-		ldapExpand(logger, conn, ldapAddress, ldapPort, ldapUser, ldapPassword, dialTimeout, &result, gssapiOptions)
+		ldapExpand(logger, session, &result)
 	}
 
 	// Return filled AD struct
 	return &result
 }
 
-// ldapExpand enriches the AD result struct with user data retrieved via a second LDAP query
+// ldapExpand enriches the AD result struct with owner data retrieved via a second LDAP query
 func ldapExpand(
 	logger utils.Logger,
-	conn *ldap.Conn,
-	ldapAddress string,
-	ldapPort int,
-	ldapUser string,
-	ldapPassword string,
-	dialTimeout time.Duration,
+	session *LdapSession,
 	result *Ad,
-	gssapiOptions *GSSAPIOptions,
 ) {
-	// Prepare temporary vars
-	var errConn error
-
-	// Translate managedBy DN into new ldap address, search CN and base DN
-	newLdapAddress, newSearchCn, newBaseDn := parseDn(result.ManagedBy)
-
-	// Connect to new domain controller, if necessary
-	if newLdapAddress != ldapAddress {
-
-		// Close old LDAP connection
-		conn.Close()
-
-		// Connect to LDAP with appropriate authentication method
-		var conn *ldap.Conn
-		if gssapiOptions != nil {
-			// Connect with GSSAPI
-			conn, errConn = ldapConnectWithGSSAPI(logger, newLdapAddress, ldapPort, ldapUser, ldapPassword, dialTimeout, *gssapiOptions)
-		} else {
-			// Connect with standard LDAP authentication
-			conn, errConn = ldapConnect(logger, newLdapAddress, ldapPort, ldapUser, ldapPassword, dialTimeout)
-		}
-		if errConn != nil {
-			logger.Debugf("LDAP connection to '%s:%d' failed: %s", newLdapAddress, ldapPort, errConn)
-			return
-		} else {
-			logger.Debugf("LDAP connection to '%s:%d' succeeded.", newLdapAddress, ldapPort)
-		}
-
-		// Make sure connection is closed on exit
-		defer conn.Close()
-	}
-
-	// Prepare search
-	logger.Debugf("LDAP searching for user '%s' in '%s'.", newSearchCn, newLdapAddress)
-	userSearch := ldap.NewSearchRequest(
-		newBaseDn, // The base dn to search
-		ldap.ScopeWholeSubtree,
-		ldap.NeverDerefAliases,
-		0,
-		0,
-		false,
-		fmt.Sprintf("(&(objectClass=user)(cn=%s))", newSearchCn), // The filter to apply
-		[]string{
-			"cn", "department", "siemens-gid",
-		},
-		nil,
-	)
 
-	// Execute search
-	userResult, errUserSearch := conn.Search(userSearch)
-	if errUserSearch != nil {
-		logger.Warningf(
-			"LDAP search for user '%s' in '%s' failed: %s", newSearchCn, newLdapAddress, errUserSearch)
+	// Read the user, group or contact referenced by managedBy
+	logger.Debugf("LDAP searching for owner '%s'.", result.ManagedBy)
+	owner, errOwner := ldapOwner(logger, session, result.ManagedBy, session.Options.ExpandOwnerMembers)
+	if errOwner != nil {
+		logger.Warningf("LDAP search for owner '%s' failed: %s", result.ManagedBy, errOwner)
 		return
-
 	}
 
 	// Check for result
-	if len(userResult.Entries) == 0 {
-		logger.Debugf("LDAP search for user '%s' in '%s' did not return results.", newSearchCn, newLdapAddress)
-		return
-	} else if len(userResult.Entries) > 1 {
-		logger.Warningf(
-			"LDAP search for user '%s' in '%s' returned ambiguous results.", newSearchCn, newLdapAddress)
+	if owner == nil {
+		logger.Debugf("LDAP search for owner '%s' did not return results.", result.ManagedBy)
 		return
 	}
 
 	// Read standard values and add them to result struct
-	result.ManagedByCn = userResult.Entries[0].GetAttributeValue("cn")
-	result.ManagedByGid = userResult.Entries[0].GetAttributeValue("siemens-gid")
-	result.ManagedByDepartment = userResult.Entries[0].GetAttributeValue("department")
+	result.Owner = owner
+	result.ManagedByCn = owner.Cn
+	result.ManagedByEmployeeId = owner.EmployeeId
+	result.ManagedByCostCenter = owner.CostCenter
+	result.ManagedByDepartment = owner.Department
+}
+
+// ldapConnectAuto establishes an LDAP connection using the authentication method selected by the options. Dial and
+// bind are retried as one unit according to the retry policy, as a connection failing during the bind is unusable.
+func ldapConnectAuto(logger utils.Logger, ldapAddress string, options LdapOptions) (*ldap.Conn, error) {
+	var conn *ldap.Conn
+	errConn := withRetry(logger, options.Retry, "LDAP connect to '"+ldapAddress+"'", func() error {
+		var err error
+		conn, err = ldapConnectOnce(logger, ldapAddress, options)
+		return err
+	})
+	return conn, errConn
+}
+
+// ldapConnectOnce dials and binds a single LDAP connection using the authentication method selected by the options
+func ldapConnectOnce(logger utils.Logger, ldapAddress string, options LdapOptions) (*ldap.Conn, error) {
+	if options.GSSAPI != nil {
+		// Connect with GSSAPI
+		return ldapConnectWithGSSAPI(logger, ldapAddress, options)
+	}
+	if options.NTLM != nil {
+		// Connect with NTLM
+		return ldapConnectWithNTLM(logger, ldapAddress, options)
+	}
+	if options.External != nil {
+		// Connect with client certificate
+		return ldapConnectWithExternal(logger, ldapAddress, options)
+	}
+
+	// Connect with standard LDAP authentication
+	return ldapConnect(logger, ldapAddress, options)
 }
 
 // ldapConnect establishes an LDAP connection to an Active Directory service
 func ldapConnect(
 	logger utils.Logger,
 	ldapAddress string,
-	ldapPort int,
-	ldapUser string,
-	ldapPassword string,
-	dialTimeout time.Duration,
+	options LdapOptions,
 ) (*ldap.Conn, error) {
 
-	// Prepare the ldap url by trimming any protocol specifications.
-	baseUrl := strings.TrimPrefix(ldapAddress, "ldap://")
-	baseUrl = strings.TrimPrefix(baseUrl, "ldaps://")
-	baseUrl = strings.TrimPrefix(baseUrl, "ldapi://")
-
-	// Prepare the ldap options - namely the timeout.
-	opts := []ldap.DialOpt{
-		ldap.DialWithDialer(&net.Dialer{Timeout: dialTimeout}), // DialWithDialer updates net.Dialer in DialContext.
-	}
-
-	// First of try to establish an ldaps connection right away.
-	conn, errDialS := ldap.DialURL(fmt.Sprintf("ldaps://%s:%d", baseUrl, ldapPort), opts...)
-	if errDialS != nil {
-		logger.Debugf("LDAPS connection to '%s:%d' failed: %s", ldapAddress, ldapPort, errDialS)
-
-		// Try to establish a normal ldap connection
-		var errDial error
-		conn, errDial = ldap.DialURL(fmt.Sprintf("ldap://%s:%d", baseUrl, ldapPort), opts...)
-		if errDial != nil {
-			logger.Debugf("LDAP connection to '%s:%d' failed: %s", ldapAddress, ldapPort, errDial)
-			return nil, fmt.Errorf("neither LDAP nor LDAPS connection accepted")
-		}
-
-		// Try to upgrade to TLS
-		//errTls := conn.StartTLS(utils.InsecureTlsConfigFactory()) // Insecure, because this is not a user interface, we are trying to discover content...
-		//if errTls != nil {
-		//	logger.Debugf("StartTLS connection to '%s:%d' failed: %s", ldapAddress, ldapPort, errTls)
-		//}
+	// Open LDAP connection with the configured transport
+	conn, errOpen := ldapOpen(logger, ldapAddress, options)
+	if errOpen != nil {
+		return nil, errOpen
 	}
 
 	// Bind LDAP connection, with authentication if available, without otherwise
-	if len(ldapUser) > 0 && len(ldapPassword) > 0 {
-		errBind := conn.Bind(ldapUser, ldapPassword)
+	if len(options.User) > 0 && len(options.Password) > 0 {
+		errBind := conn.Bind(options.User, options.Password)
 		if errBind != nil {
 			conn.Close()
-			return nil, fmt.Errorf("authenticated bind error: %s", errBind)
+			return nil, fmt.Errorf("authenticated bind error: %w", errBind)
 		}
 	} else {
 		errAuth := conn.UnauthenticatedBind("anonymous")
 		if errAuth != nil {
 			conn.Close()
-			return nil, fmt.Errorf("bind error: %s", errAuth)
+			return nil, fmt.Errorf("bind error: %w", errAuth)
 		}
 	}
 
@@ -0,0 +1,32 @@
+package active_directory
+
+import (
+	"testing"
+)
+
+func TestLdapOptionsPort(t *testing.T) {
+	tests := []struct {
+		name    string
+		options LdapOptions
+		want    int
+	}{
+		{"plain", LdapOptions{}, defaultLdapPort},
+		{"StartTLS", LdapOptions{TLS: &TLSOptions{Mode: TransportStartTLS}}, defaultLdapPort},
+		{"LDAPS", LdapOptions{TLS: &TLSOptions{Mode: TransportLDAPS}}, defaultLdapsPort},
+		{"explicit port", LdapOptions{Port: 3269, TLS: &TLSOptions{Mode: TransportLDAPS}}, 3269},
+		{"SASL EXTERNAL defaults to LDAPS", LdapOptions{External: &ExternalOptions{}}, defaultLdapsPort},
+		{
+			"SASL EXTERNAL with StartTLS",
+			LdapOptions{External: &ExternalOptions{}, TLS: &TLSOptions{Mode: TransportStartTLS}},
+			defaultLdapPort,
+		},
+		{"GSSAPI takes precedence", LdapOptions{GSSAPI: &GSSAPIOptions{}, External: &ExternalOptions{}}, defaultLdapPort},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.options.port(); got != tt.want {
+				t.Errorf("port() = %d, want %d", got, tt.want)
+			}
+		})
+	}
+}
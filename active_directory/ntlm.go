@@ -0,0 +1,74 @@
+package active_directory
+
+import (
+	"encoding/hex"
+	"fmt"
+	"strings"
+
+	"github.com/go-ldap/ldap/v3"
+	"github.com/siemens/GoScans/utils"
+)
+
+// NTLMOptions holds configuration for NTLM LDAP connections
+type NTLMOptions struct {
+	Domain string // Optional NetBIOS or DNS domain name, if not contained in the user name or learned from the server
+	Hash   string // Optional hex encoded NT hash used instead of the password (pass-the-hash)
+}
+
+// splitNtlmUser splits a user name given as 'DOMAIN\user' into domain and user name. User principal names
+// (user@domain.tld) are passed on unchanged with an empty domain, as NTLM accepts them as user name.
+func splitNtlmUser(ldapUser string, defaultDomain string) (string, string) {
+	if domain, user, found := strings.Cut(ldapUser, "\\"); found {
+		return domain, user
+	}
+	if strings.Contains(ldapUser, "@") {
+		return "", ldapUser
+	}
+	return defaultDomain, ldapUser
+}
+
+// ldapConnectWithNTLM establishes an LDAP connection with NTLM authentication
+func ldapConnectWithNTLM(
+	logger utils.Logger,
+	ldapAddress string,
+	ldapOptions LdapOptions,
+) (*ldap.Conn, error) {
+	options := *ldapOptions.NTLM
+
+	// Validate required options
+	if ldapOptions.User == "" {
+		return nil, fmt.Errorf("user name is required for NTLM authentication")
+	}
+	if options.Hash == "" && ldapOptions.Password == "" {
+		return nil, fmt.Errorf("password or NT hash is required for NTLM authentication")
+	}
+	if options.Hash != "" {
+		hash, errHash := hex.DecodeString(options.Hash)
+		if errHash != nil || len(hash) != 16 {
+			return nil, fmt.Errorf("NT hash must be 32 hex characters")
+		}
+	}
+
+	// Open LDAP connection with the configured transport
+	conn, err := ldapOpen(logger, ldapAddress, ldapOptions)
+	if err != nil {
+		logger.Debugf("LDAP connection to '%s:%d' failed: %s", ldapAddress, ldapOptions.port(), err)
+		return nil, err
+	}
+
+	// Bind using NTLM, either with the hash or with the password
+	domain, user := splitNtlmUser(ldapOptions.User, options.Domain)
+	if options.Hash != "" {
+		err = conn.NTLMBindWithHash(domain, user, options.Hash)
+	} else {
+		err = conn.NTLMBind(domain, user, ldapOptions.Password)
+	}
+	if err != nil {
+		conn.Close()
+		logger.Debugf("NTLM bind failed: %s", err)
+		return nil, fmt.Errorf("NTLM bind failed: %w", err)
+	}
+
+	logger.Debugf("NTLM bind successful as '%s\\%s'", domain, user)
+	return conn, nil
+}
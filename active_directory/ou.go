@@ -0,0 +1,200 @@
+package active_directory
+
+import (
+	"encoding/json"
+	"io"
+	"strings"
+
+	"github.com/go-ldap/ldap/v3"
+	"github.com/siemens/GoScans/utils"
+)
+
+// OrganizationalUnit is a node of the OU tree of a domain. The root node is the domain object itself.
+type OrganizationalUnit struct {
+	Name              string                `ldap:"name" json:"name"`
+	DistinguishedName string                `ldap:"distinguishedName" json:"distinguishedName"`
+	Description       []string              `ldap:"description" json:"description,omitempty"`
+	ManagedBy         string                `ldap:"managedBy" json:"managedBy,omitempty"`
+	GpLink            string                `ldap:"gPLink" json:"-"`
+	GpOptions         int                   `ldap:"gPOptions" json:"-"`
+	Links             []GpoLink             `json:"links,omitempty"` // GPOs linked to the OU, by link order
+	BlockInheritance  bool                  `json:"blockInheritance"`
+	Computers         int                   `json:"computers"`      // Computers directly within the OU, only counted on request
+	Users             int                   `json:"users"`          // Users directly within the OU, only counted on request
+	TotalComputers    int                   `json:"totalComputers"` // Computers within the OU and its children, only counted on request
+	TotalUsers        int                   `json:"totalUsers"`     // Users within the OU and its children, only counted on request
+	Children          []*OrganizationalUnit `json:"children,omitempty"`
+}
+
+// WriteJson writes the OU and its children as indented JSON
+func (o *OrganizationalUnit) WriteJson(w io.Writer) error {
+	encoder := json.NewEncoder(w)
+	encoder.SetIndent("", "  ")
+	return encoder.Encode(o)
+}
+
+// ouKey normalizes a DN for lookups within the OU tree
+func ouKey(dn string) string {
+	parsed, errDn := ParseDn(dn)
+	if errDn != nil {
+		return strings.ToLower(dn)
+	}
+	return strings.ToLower(parsed.String())
+}
+
+// newOrganizationalUnit decodes an LDAP entry into an OU node
+func newOrganizationalUnit(logger utils.Logger, entry *ldap.Entry) *OrganizationalUnit {
+	ou := &OrganizationalUnit{}
+	errDecode := Unmarshal(entry, ou)
+	if errDecode != nil {
+		logger.Errorf("Could not decode LDAP entry of OU '%s': %s", entry.DN, errDecode)
+	}
+	links, errLinks := parseGpLink(ou.GpLink)
+	if errLinks != nil {
+		logger.Warningf("Could not parse gPLink of '%s': %s", entry.DN, errLinks)
+	}
+	ou.Links = links
+	ou.BlockInheritance = ou.GpOptions&gpOptionsBlockInheritance != 0
+	return ou
+}
+
+// OuTree reads the OU hierarchy of the session's domain. The root node is the domain object, OUs nested in other
+// containers are attached to the closest OU above them. If requested, each node is annotated with the number of
+// computers and users it contains. Objects within other containers (e.g. CN=Computers) are counted for the
+// closest OU above them.
+func OuTree(logger utils.Logger, session *LdapSession, withCounts bool) (*OrganizationalUnit, error) {
+
+	// Read domain object and OUs
+	baseDn := session.BaseDn()
+	attributes := Attributes(OrganizationalUnit{})
+	rootSearch := ldap.NewSearchRequest(
+		baseDn, // The object to read
+		ldap.ScopeBaseObject,
+		ldap.NeverDerefAliases,
+		0,
+		0,
+		false,
+		"(objectClass=*)", // The filter to apply
+		attributes,
+		nil,
+	)
+	rootResult, errRoot := session.search(logger, rootSearch)
+	if errRoot != nil {
+		return nil, errRoot
+	}
+	if len(rootResult.Entries) == 0 {
+		return nil, nil
+	}
+	ouSearch := ldap.NewSearchRequest(
+		baseDn, // The base dn to search
+		ldap.ScopeWholeSubtree,
+		ldap.NeverDerefAliases,
+		0,
+		0,
+		false,
+		"(objectClass=organizationalUnit)", // The filter to apply
+		attributes,
+		nil,
+	)
+	ouResult, errOu := session.searchPaged(logger, ouSearch)
+	if errOu != nil {
+		return nil, errOu
+	}
+
+	// Index nodes by DN
+	root := newOrganizationalUnit(logger, rootResult.Entries[0])
+	nodes := map[string]*OrganizationalUnit{ouKey(rootResult.Entries[0].DN): root}
+	entries := ouResult.Entries
+	for _, entry := range entries {
+		nodes[ouKey(entry.DN)] = newOrganizationalUnit(logger, entry)
+	}
+
+	// Attach each OU to the closest OU above it, in the order returned by the server
+	for _, entry := range entries {
+		node := nodes[ouKey(entry.DN)]
+		parent := ouParent(nodes, entry.DN)
+		if parent == nil || parent == node {
+			logger.Debugf("No parent found for OU '%s'.", entry.DN)
+			continue
+		}
+		parent.Children = append(parent.Children, node)
+	}
+
+	// Count objects, if requested
+	if withCounts {
+		errCount := ouCount(logger, session, nodes, userFilter, func(ou *OrganizationalUnit) { ou.Users++ })
+		if errCount != nil {
+			return nil, errCount
+		}
+		errCount = ouCount(logger, session, nodes, "(objectClass=computer)", func(ou *OrganizationalUnit) { ou.Computers++ })
+		if errCount != nil {
+			return nil, errCount
+		}
+		ouTotals(root)
+	}
+
+	// Return tree
+	return root, nil
+}
+
+// ouParent returns the node of the closest OU above the object with the given DN
+func ouParent(nodes map[string]*OrganizationalUnit, dn string) *OrganizationalUnit {
+	parsed, errDn := ParseDn(dn)
+	if errDn != nil {
+		return nil
+	}
+	for parent := parsed.Parent(); parent != nil && !parent.IsEmpty(); parent = parent.Parent() {
+		if node, ok := nodes[strings.ToLower(parent.String())]; ok {
+			return node
+		}
+	}
+	return nil
+}
+
+// ouCount searches all objects matching the filter and increments the counter of the closest OU above each
+func ouCount(
+	logger utils.Logger,
+	session *LdapSession,
+	nodes map[string]*OrganizationalUnit,
+	filter string,
+	increment func(ou *OrganizationalUnit),
+) error {
+
+	// Search objects, only their DNs are required
+	countSearch := ldap.NewSearchRequest(
+		session.BaseDn(), // The base dn to search
+		ldap.ScopeWholeSubtree,
+		ldap.NeverDerefAliases,
+		0,
+		0,
+		false,
+		filter,          // The filter to apply
+		[]string{"1.1"}, // No attributes
+		nil,
+	)
+	countResult, errSearch := session.searchPaged(logger, countSearch)
+	if errSearch != nil {
+		return errSearch
+	}
+
+	// Count objects
+	for _, entry := range countResult.Entries {
+		if node := ouParent(nodes, entry.DN); node != nil {
+			increment(node)
+		}
+	}
+
+	// Return nil as everything went fine
+	return nil
+}
+
+// ouTotals sums up the counts of each node and its children
+func ouTotals(ou *OrganizationalUnit) {
+	ou.TotalComputers = ou.Computers
+	ou.TotalUsers = ou.Users
+	for _, child := range ou.Children {
+		ouTotals(child)
+		ou.TotalComputers += child.TotalComputers
+		ou.TotalUsers += child.TotalUsers
+	}
+}
@@ -0,0 +1,131 @@
+package active_directory
+
+import (
+	"strings"
+
+	"github.com/go-ldap/ldap/v3"
+	"github.com/siemens/GoScans/utils"
+)
+
+// OwnerKind describes the type of directory object referenced as owner via managedBy
+type OwnerKind string
+
+const (
+	OwnerKindUnknown       OwnerKind = ""
+	OwnerKindUser          OwnerKind = "user"
+	OwnerKindInetOrgPerson OwnerKind = "inetOrgPerson"
+	OwnerKindGroup         OwnerKind = "group"
+	OwnerKindContact       OwnerKind = "contact"
+	OwnerKindComputer      OwnerKind = "computer"
+
+	OwnerKindForeignSecurityPrincipal OwnerKind = "foreignSecurityPrincipal" // Only occurs as group member
+)
+
+// ownerFilter matches all object types supported as owner. Computers and inetOrgPersons are subclasses of user.
+const ownerFilter = "(|(objectClass=user)(objectClass=group)(objectClass=contact))"
+
+// Owner describes the object referenced by the managedBy attribute of a computer
+type Owner struct {
+	Kind              OwnerKind
+	DistinguishedName string              `ldap:"distinguishedName"`
+	Cn                string              `ldap:"cn"`
+	DisplayName       string              `ldap:"displayName"`
+	Mail              string              `ldap:"mail"`
+	Department        string              `ldap:"department"`
+	EmployeeId        string              // Attribute as per AttributeProfile
+	CostCenter        string              // Attribute as per AttributeProfile
+	Extra             map[string][]string // Additional attributes as configured by the AttributeProfile
+	Members           []*Owner            // Direct members of group owners, only resolved on request
+}
+
+// ownerAttributes lists the attributes to request for owner objects, in addition to the ones of the profile. The
+// object class determines the kind, the members are resolved for group owners.
+var ownerAttributes = append(Attributes(Owner{}), "objectClass", "member")
+
+// ownerKind derives the owner kind from the object classes of an entry. Subclasses are checked first.
+func ownerKind(objectClasses []string) OwnerKind {
+	has := func(class string) bool {
+		for _, objectClass := range objectClasses {
+			if strings.EqualFold(objectClass, class) {
+				return true
+			}
+		}
+		return false
+	}
+	switch {
+	case has("computer"):
+		return OwnerKindComputer
+	case has("inetOrgPerson"):
+		return OwnerKindInetOrgPerson
+	case has("user"):
+		return OwnerKindUser
+	case has("group"):
+		return OwnerKindGroup
+	case has("contact"):
+		return OwnerKindContact
+	case has("foreignSecurityPrincipal"):
+		return OwnerKindForeignSecurityPrincipal
+	default:
+		return OwnerKindUnknown
+	}
+}
+
+// newOwner converts an LDAP entry into an owner record, reading deployment specific attributes as per profile
+func newOwner(entry *ldap.Entry, profile *AttributeProfile) *Owner {
+	owner := &Owner{
+		Kind:  ownerKind(entry.GetAttributeValues("objectClass")),
+		Extra: extraAttributes(entry, profile.OwnerAttributes),
+	}
+	_ = Unmarshal(entry, owner) // Owner only consists of string attributes, which cannot fail decoding
+	if profile.EmployeeId != "" {
+		owner.EmployeeId = entry.GetAttributeValue(profile.EmployeeId)
+	}
+	if profile.CostCenter != "" {
+		owner.CostCenter = entry.GetAttributeValue(profile.CostCenter)
+	}
+	return owner
+}
+
+// extraAttributes collects the values of the given additional attributes from an LDAP entry
+func extraAttributes(entry *ldap.Entry, attributes []string) map[string][]string {
+	if len(attributes) == 0 {
+		return nil
+	}
+	extra := make(map[string][]string, len(attributes))
+	for _, attribute := range attributes {
+		if values := entry.GetAttributeValues(attribute); len(values) > 0 {
+			extra[attribute] = values
+		}
+	}
+	return extra
+}
+
+// ldapOwner reads the owner object with the given DN. Direct members of groups are resolved if requested.
+func ldapOwner(logger utils.Logger, session *LdapSession, dn string, withMembers bool) (*Owner, error) {
+
+	// Prepare attributes to request
+	profile := profileOrDefault(session.Options.Attributes)
+	attributes := append(append([]string{}, ownerAttributes...), profile.ownerAttributes()...)
+
+	// Read the referenced object
+	entry, ownerSession, errOwner := resolveReferenceWithSession(logger, session, dn, ownerFilter, attributes)
+	if errOwner != nil || entry == nil {
+		return nil, errOwner
+	}
+	owner := newOwner(entry, profile)
+
+	// Resolve group members, remaining ranges are read from the domain the group was read from
+	if withMembers && owner.Kind == OwnerKindGroup {
+		members, errMembers := ldapRangedStrings(logger, ownerSession, entry, "member")
+		if errMembers != nil {
+			logger.Warningf("LDAP members of owner '%s' could not be read completely: %s", dn, errMembers)
+		}
+		memberEntries := resolveReferences(logger, session, members, ownerFilter, attributes)
+		for _, memberEntry := range memberEntries {
+			owner.Members = append(owner.Members, newOwner(memberEntry, profile))
+		}
+	}
+
+	// Return owner
+	return owner, nil
+}
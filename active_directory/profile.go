@@ -0,0 +1,95 @@
+package active_directory
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+)
+
+// AttributeProfile maps deployment specific directory attributes onto result fields. Different organisations
+// store e.g. the employee ID in different, sometimes custom, schema attributes.
+type AttributeProfile struct {
+	EmployeeId         string   `json:"employeeId"`         // Owner attribute holding the employee ID, e.g. 'employeeID' or 'siemens-gid'
+	CostCenter         string   `json:"costCenter"`         // Optional owner attribute holding the cost center, e.g. 'departmentNumber'
+	ComputerAttributes []string `json:"computerAttributes"` // Additional computer attributes to store in Ad.Extra
+	OwnerAttributes    []string `json:"ownerAttributes"`    // Additional owner attributes to store in Owner.Extra
+}
+
+// DefaultAttributeProfile returns a profile using the attributes of the default Active Directory schema
+func DefaultAttributeProfile() *AttributeProfile {
+	return &AttributeProfile{
+		EmployeeId: "employeeID",
+	}
+}
+
+// LoadAttributeProfile reads an attribute profile from a JSON file. Fields not set in the file keep the values
+// of the default profile.
+func LoadAttributeProfile(path string) (*AttributeProfile, error) {
+
+	// Read file
+	data, errRead := os.ReadFile(path)
+	if errRead != nil {
+		return nil, fmt.Errorf("could not read attribute profile: %w", errRead)
+	}
+
+	// Parse file on top of the default profile
+	profile := DefaultAttributeProfile()
+	errParse := json.Unmarshal(data, profile)
+	if errParse != nil {
+		return nil, fmt.Errorf("could not parse attribute profile '%s': %w", path, errParse)
+	}
+
+	// Return profile
+	return profile, nil
+}
+
+// profileOrDefault returns the given profile, or the default profile if none is given
+func profileOrDefault(profile *AttributeProfile) *AttributeProfile {
+	if profile == nil {
+		return DefaultAttributeProfile()
+	}
+	return profile
+}
+
+// ownerAttributes returns the owner attributes configured by the profile
+func (p *AttributeProfile) ownerAttributes() []string {
+	var attributes []string
+	if p.EmployeeId != "" {
+		attributes = append(attributes, p.EmployeeId)
+	}
+	if p.CostCenter != "" {
+		attributes = append(attributes, p.CostCenter)
+	}
+	return append(attributes, p.OwnerAttributes...)
+}
+
+// adFieldAttributes returns the attributes configured by the profile for fields of the Ad struct, by field name.
+// They take precedence over the field's ldap tag.
+func (p *AttributeProfile) adFieldAttributes() map[string]string {
+	fields := map[string]string{}
+	if p.EmployeeId != "" {
+		fields["ManagedByEmployeeId"] = p.EmployeeId
+	}
+	if p.CostCenter != "" {
+		fields["ManagedByCostCenter"] = p.CostCenter
+	}
+	return fields
+}
+
+// userFieldAttributes returns the attributes configured by the profile for fields of the User struct, by field name
+func (p *AttributeProfile) userFieldAttributes() map[string]string {
+	fields := map[string]string{}
+	if p.EmployeeId != "" {
+		fields["EmployeeId"] = p.EmployeeId
+	}
+	return fields
+}
+
+// userAttributes returns the attributes to request for users, including the deployment specific ones
+func (p *AttributeProfile) userAttributes() []string {
+	attributes := Attributes(User{})
+	for _, attribute := range p.userFieldAttributes() {
+		attributes = append(attributes, attribute)
+	}
+	return attributes
+}
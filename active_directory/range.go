@@ -0,0 +1,97 @@
+package active_directory
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/go-ldap/ldap/v3"
+	"github.com/siemens/GoScans/utils"
+)
+
+// rangedAttribute looks up the values of an attribute returned via range retrieval (e.g. member;range=0-1499),
+// which Active Directory applies to multi-valued attributes exceeding MaxValRange. It returns the values, the
+// upper bound of the range and whether the entry contains the attribute in ranged form at all. An upper bound
+// of -1 marks the last range ('*').
+func rangedAttribute(entry *ldap.Entry, attribute string) ([][]byte, int, bool) {
+	prefix := strings.ToLower(attribute) + ";range="
+	for _, entryAttribute := range entry.Attributes {
+		name := strings.ToLower(entryAttribute.Name)
+		if !strings.HasPrefix(name, prefix) {
+			continue
+		}
+		_, high, found := strings.Cut(name[len(prefix):], "-")
+		if !found {
+			continue
+		}
+		if high == "*" {
+			return entryAttribute.ByteValues, -1, true
+		}
+		upper, errUpper := strconv.Atoi(high)
+		if errUpper != nil {
+			continue
+		}
+		return entryAttribute.ByteValues, upper, true
+	}
+	return nil, 0, false
+}
+
+// ldapRangedValues returns all raw values of an attribute of the given entry. If the server returned the attribute
+// in ranges, the remaining ranges are read with follow-up queries on the entry's DN.
+func ldapRangedValues(logger utils.Logger, session *LdapSession, entry *ldap.Entry, attribute string) ([][]byte, error) {
+
+	// Return complete values directly
+	values, upper, ranged := rangedAttribute(entry, attribute)
+	if !ranged {
+		return entry.GetRawAttributeValues(attribute), nil
+	}
+
+	// Read remaining ranges until the last one is returned
+	for upper >= 0 {
+		rangeAttribute := fmt.Sprintf("%s;range=%d-*", attribute, upper+1)
+		logger.Debugf("LDAP reading '%s' of '%s'.", rangeAttribute, entry.DN)
+		rangeSearch := ldap.NewSearchRequest(
+			entry.DN, // The object to read
+			ldap.ScopeBaseObject,
+			ldap.NeverDerefAliases,
+			0,
+			0,
+			false,
+			"(objectClass=*)", // The filter to apply
+			[]string{rangeAttribute},
+			nil,
+		)
+		rangeResult, errSearch := session.search(logger, rangeSearch)
+		if errSearch != nil {
+			return values, errSearch
+		}
+		if len(rangeResult.Entries) == 0 {
+			return values, fmt.Errorf("object '%s' vanished during range retrieval", entry.DN)
+		}
+
+		// Append values of the range
+		var rangeValues [][]byte
+		previous := upper
+		rangeValues, upper, ranged = rangedAttribute(rangeResult.Entries[0], attribute)
+		if !ranged {
+			return values, fmt.Errorf("range retrieval of '%s' returned no range", attribute)
+		}
+		if upper >= 0 && upper <= previous {
+			return values, fmt.Errorf("range retrieval of '%s' did not advance", attribute)
+		}
+		values = append(values, rangeValues...)
+	}
+
+	// Return all values
+	return values, nil
+}
+
+// ldapRangedStrings works like ldapRangedValues, but returns the values as strings
+func ldapRangedStrings(logger utils.Logger, session *LdapSession, entry *ldap.Entry, attribute string) ([]string, error) {
+	values, err := ldapRangedValues(logger, session, entry, attribute)
+	strs := make([]string, 0, len(values))
+	for _, value := range values {
+		strs = append(strs, string(value))
+	}
+	return strs, err
+}
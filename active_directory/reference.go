@@ -0,0 +1,166 @@
+package active_directory
+
+import (
+	"fmt"
+
+	"github.com/go-ldap/ldap/v3"
+	"github.com/siemens/GoScans/utils"
+)
+
+// holds returns whether the object with the given DN is stored within the naming contexts of the session's server.
+// The most specific naming context containing the DN must also share its domain components, as the naming context
+// of a parent domain (e.g. dc=domain,dc=tld) is an ancestor of the objects of its child domains as well.
+func (s *LdapSession) holds(dn *Dn) bool {
+
+	// Collect naming contexts of the server
+	namingContexts := []string{s.BaseDn()}
+	if s.RootDse != nil {
+		namingContexts = append(namingContexts, s.RootDse.NamingContexts...)
+	}
+
+	// Select the most specific naming context containing the DN
+	var closest *Dn
+	for _, namingContext := range namingContexts {
+		contextDn, errContext := ParseDn(namingContext)
+		if errContext != nil || !(contextDn.Equal(dn) || contextDn.IsAncestorOf(dn)) {
+			continue
+		}
+		if closest == nil || len(contextDn.Rdns()) > len(closest.Rdns()) {
+			closest = contextDn
+		}
+	}
+
+	// Check whether the DN belongs to the same domain as the naming context
+	return closest != nil && closest.DomainDn().Equal(dn.DomainDn())
+}
+
+// sessionFor returns a session able to read the object with the given DN. Objects of other domains are read via
+// an additional session to that domain, which is kept open until the original session is closed.
+func (s *LdapSession) sessionFor(logger utils.Logger, dn *Dn) (*LdapSession, error) {
+
+	// Use this session for objects within its own naming contexts
+	if s.holds(dn) {
+		return s, nil
+	}
+
+	// Reuse existing session to the other domain
+	domain := dn.Domain()
+	if domain == "" {
+		return nil, fmt.Errorf("could not derive domain of '%s'", dn)
+	}
+	if foreign, ok := s.foreign[domain]; ok {
+		return foreign, nil
+	}
+
+	// Connect to the other domain with the same settings, but authenticating it by its own name
+	foreign, errConn := LdapConnect(logger, domain, s.Options.forHost(domain))
+	if errConn != nil {
+		logger.Debugf("LDAP connection to '%s:%d' failed: %s", domain, s.Options.port(), errConn)
+		return nil, errConn
+	} else {
+		logger.Debugf("LDAP connection to '%s:%d' succeeded.", domain, s.Options.port())
+	}
+	if s.foreign == nil {
+		s.foreign = make(map[string]*LdapSession)
+	}
+	s.foreign[domain] = foreign
+
+	// Return session
+	return foreign, nil
+}
+
+// resolveReference reads the object referenced by a DN-valued attribute (e.g. managedBy, manager, member or
+// memberOf) with a base-object search on the exact DN. A nil entry is returned if the object does not exist or
+// does not match the filter.
+func resolveReference(
+	logger utils.Logger,
+	session *LdapSession,
+	dn string,
+	filter string,
+	attributes []string,
+) (*ldap.Entry, error) {
+	entry, _, err := resolveReferenceWithSession(logger, session, dn, filter, attributes)
+	return entry, err
+}
+
+// resolveReferenceWithSession works like resolveReference, but additionally returns the session the object was
+// read with, which is the one to use for follow-up queries on the object (e.g. range retrieval)
+func resolveReferenceWithSession(
+	logger utils.Logger,
+	session *LdapSession,
+	dn string,
+	filter string,
+	attributes []string,
+) (*ldap.Entry, *LdapSession, error) {
+
+	// Parse referenced DN
+	referenceDn, errDn := ParseDn(dn)
+	if errDn != nil {
+		return nil, nil, fmt.Errorf("could not parse DN '%s': %w", dn, errDn)
+	}
+	if referenceDn.IsEmpty() {
+		return nil, nil, fmt.Errorf("empty DN")
+	}
+
+	// Select session responsible for the referenced object
+	referenceSession, errSession := session.sessionFor(logger, referenceDn)
+	if errSession != nil {
+		return nil, nil, errSession
+	}
+
+	// Prepare search
+	if filter == "" {
+		filter = "(objectClass=*)"
+	}
+	logger.Debugf("LDAP reading '%s' in '%s'.", dn, referenceSession.Address)
+	referenceSearch := ldap.NewSearchRequest(
+		dn, // The object to read
+		ldap.ScopeBaseObject,
+		ldap.NeverDerefAliases,
+		0,
+		0,
+		false,
+		filter, // The filter to apply
+		attributes,
+		nil,
+	)
+
+	// Execute search
+	referenceResult, errSearch := referenceSession.search(logger, referenceSearch)
+	if ldap.IsErrorWithCode(errSearch, ldap.LDAPResultNoSuchObject) {
+		return nil, referenceSession, nil
+	} else if errSearch != nil {
+		return nil, referenceSession, errSearch
+	}
+
+	// Check for result
+	if len(referenceResult.Entries) == 0 {
+		return nil, referenceSession, nil
+	}
+
+	// Return entry
+	return referenceResult.Entries[0], referenceSession, nil
+}
+
+// resolveReferences reads all objects referenced by a multi-valued DN attribute (e.g. member or memberOf).
+// References that cannot be read are logged and skipped.
+func resolveReferences(
+	logger utils.Logger,
+	session *LdapSession,
+	dns []string,
+	filter string,
+	attributes []string,
+) []*ldap.Entry {
+	var entries []*ldap.Entry
+	for _, dn := range dns {
+		entry, err := resolveReference(logger, session, dn, filter, attributes)
+		if err != nil {
+			logger.Debugf("LDAP reference '%s' could not be resolved: %s", dn, err)
+			continue
+		}
+		if entry != nil {
+			entries = append(entries, entry)
+		}
+	}
+	return entries
+}
@@ -0,0 +1,84 @@
+package active_directory
+
+import (
+	"testing"
+
+	"github.com/siemens/GoScans/utils"
+)
+
+// newParentSession returns a session to the forest root domain corp.local, without connection
+func newParentSession() *LdapSession {
+	return &LdapSession{
+		Address: "corp.local",
+		RootDse: &RootDse{
+			DefaultNamingContext:       "DC=corp,DC=local",
+			ConfigurationNamingContext: "CN=Configuration,DC=corp,DC=local",
+			SchemaNamingContext:        "CN=Schema,CN=Configuration,DC=corp,DC=local",
+			RootDomainNamingContext:    "DC=corp,DC=local",
+			NamingContexts: []string{
+				"DC=corp,DC=local",
+				"CN=Configuration,DC=corp,DC=local",
+				"CN=Schema,CN=Configuration,DC=corp,DC=local",
+				"DC=DomainDnsZones,DC=corp,DC=local",
+				"DC=ForestDnsZones,DC=corp,DC=local",
+			},
+		},
+	}
+}
+
+func TestSessionHolds(t *testing.T) {
+	tests := []struct {
+		name string
+		dn   string
+		want bool
+	}{
+		{"domain object", "DC=corp,DC=local", true},
+		{"object of the domain", "CN=host,OU=Servers,DC=corp,DC=local", true},
+		{"configuration", "CN=Sites,CN=Configuration,DC=corp,DC=local", true},
+		{"schema", "CN=Computer,CN=Schema,CN=Configuration,DC=corp,DC=local", true},
+		{"DNS application partition", "DC=host,DC=corp.local,CN=MicrosoftDNS,DC=DomainDnsZones,DC=corp,DC=local", true},
+		{"child domain object", "DC=child,DC=corp,DC=local", false},
+		{"object of the child domain", "CN=x,DC=child,DC=corp,DC=local", false},
+		{"object of the child domain's OU", "CN=x,OU=Users,DC=child,DC=corp,DC=local", false},
+		{"other forest", "CN=x,DC=other,DC=local", false},
+	}
+	session := newParentSession()
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dn, errDn := ParseDn(tt.dn)
+			if errDn != nil {
+				t.Fatalf("could not parse DN '%s': %s", tt.dn, errDn)
+			}
+			if got := session.holds(dn); got != tt.want {
+				t.Errorf("holds(%q) = %t, want %t", tt.dn, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestSessionForChildDomain(t *testing.T) {
+	logger := utils.NewTestLogger()
+	session := newParentSession()
+	child := &LdapSession{Address: "child.corp.local"}
+	session.foreign = map[string]*LdapSession{"child.corp.local": child}
+
+	// Objects of the parent domain are read with the session itself
+	parentDn, _ := ParseDn("CN=x,DC=corp,DC=local")
+	got, errSession := session.sessionFor(logger, parentDn)
+	if errSession != nil {
+		t.Fatalf("sessionFor returned error: %s", errSession)
+	}
+	if got != session {
+		t.Errorf("sessionFor(%q) returned session to '%s', want '%s'", parentDn, got.Address, session.Address)
+	}
+
+	// Objects of the child domain are read with the session to the child domain
+	childDn, _ := ParseDn("CN=x,DC=child,DC=corp,DC=local")
+	got, errSession = session.sessionFor(logger, childDn)
+	if errSession != nil {
+		t.Fatalf("sessionFor returned error: %s", errSession)
+	}
+	if got != child {
+		t.Errorf("sessionFor(%q) returned session to '%s', want '%s'", childDn, got.Address, child.Address)
+	}
+}
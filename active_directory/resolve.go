@@ -0,0 +1,117 @@
+package active_directory
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"strings"
+	"time"
+
+	"github.com/go-ldap/ldap/v3"
+	"github.com/siemens/GoScans/utils"
+)
+
+// Resolver resolves host names and addresses, *net.Resolver satisfies it
+type Resolver interface {
+	LookupIPAddr(ctx context.Context, host string) ([]net.IPAddr, error)
+	LookupAddr(ctx context.Context, addr string) ([]string, error)
+}
+
+// ResolveOptions configures the resolution of computer host names to network addresses
+type ResolveOptions struct {
+	Resolver Resolver      // Optional, defaults to the system resolver
+	Timeout  time.Duration // Optional, no timeout if not set
+}
+
+// resolver returns the configured resolver, or the system resolver if none is given
+func (o *ResolveOptions) resolver() Resolver {
+	if o == nil || o.Resolver == nil {
+		return net.DefaultResolver
+	}
+	return o.Resolver
+}
+
+// context returns a context limited by the configured timeout
+func (o *ResolveOptions) context() (context.Context, context.CancelFunc) {
+	if o == nil || o.Timeout <= 0 {
+		return context.WithCancel(context.Background())
+	}
+	return context.WithTimeout(context.Background(), o.Timeout)
+}
+
+// ResolveAddresses resolves the DNS host name of a computer to its IPv4 and IPv6 addresses and stores them in
+// the result. Resolution errors are stored in the result as well, as an unresolvable host name is a finding
+// on its own (e.g. stale computer objects).
+func ResolveAddresses(logger utils.Logger, result *Ad, options *ResolveOptions) {
+
+	// Check for host name
+	if result.DnsName == "" {
+		return
+	}
+
+	// Resolve A and AAAA records
+	ctx, cancel := options.context()
+	defer cancel()
+	addresses, errResolve := options.resolver().LookupIPAddr(ctx, result.DnsName)
+	if errResolve != nil {
+		logger.Debugf("Could not resolve '%s': %s", result.DnsName, errResolve)
+		result.AddressError = errResolve.Error()
+		return
+	}
+
+	// Store addresses
+	result.Addresses = make([]net.IP, 0, len(addresses))
+	for _, address := range addresses {
+		result.Addresses = append(result.Addresses, address.IP)
+	}
+	logger.Debugf("Resolved '%s' to %d address(es).", result.DnsName, len(result.Addresses))
+}
+
+// LdapQueryByIp looks up the computer an IP address belongs to, by resolving the address' PTR records and
+// searching computers with a matching dNSHostName. The first computer found is returned, an empty Ad struct
+// otherwise.
+func LdapQueryByIp(
+	logger utils.Logger,
+	ip string,
+	ldapAddress string,
+	options LdapOptions,
+	attributes ...string, // Optional attributes to request instead of the ones defined by the Ad struct
+) *Ad {
+
+	// Check address
+	if net.ParseIP(ip) == nil {
+		logger.Debugf("Invalid IP address '%s'.", ip)
+		return &Ad{}
+	}
+
+	// Resolve PTR records
+	ctx, cancel := options.Resolve.context()
+	defer cancel()
+	names, errResolve := options.Resolve.resolver().LookupAddr(ctx, ip)
+	if errResolve != nil {
+		logger.Debugf("Could not resolve PTR records of '%s': %s", ip, errResolve)
+		return &Ad{}
+	}
+
+	// Connect to LDAP with appropriate authentication method
+	session, errConn := LdapConnect(logger, ldapAddress, options)
+	if errConn != nil {
+		logger.Debugf("LDAP connection to '%s:%d' failed: %s", ldapAddress, options.port(), errConn)
+		return &Ad{}
+	}
+	defer session.Close()
+
+	// Search computer by each host name on the same session
+	for _, name := range names {
+		name = strings.TrimSuffix(name, ".")
+		filter := fmt.Sprintf("(&(objectClass=computer)(dNSHostName=%s))", ldap.EscapeFilter(name))
+		result := ldapQuerySession(logger, session, name, "", ldap.ScopeWholeSubtree, filter, attributes)
+		if result.Name != "" {
+			return result
+		}
+	}
+
+	// Return empty result as no computer matched
+	logger.Debugf("No computer found for IP address '%s'.", ip)
+	return &Ad{}
+}
@@ -0,0 +1,179 @@
+package active_directory
+
+import (
+	"errors"
+	"math/rand"
+	"net"
+	"syscall"
+	"time"
+
+	"github.com/go-ldap/ldap/v3"
+	"github.com/siemens/GoScans/utils"
+)
+
+// RetryOptions holds the retry policy applied to LDAP connects and searches. Dial and bind are retried as one unit.
+type RetryOptions struct {
+	MaxAttempts    int           // Total number of attempts, including the first one. Values < 1 are treated as 1.
+	InitialBackoff time.Duration // Delay before the first retry
+	MaxBackoff     time.Duration // Upper limit for the delay between two attempts
+	Multiplier     float64       // Factor the delay is multiplied with after each attempt
+	Jitter         float64       // Fraction (0-1) of the delay to randomize, to avoid retry storms
+}
+
+// DefaultRetryOptions returns a retry policy suitable for interactive discovery
+func DefaultRetryOptions() *RetryOptions {
+	return &RetryOptions{
+		MaxAttempts:    3,
+		InitialBackoff: 500 * time.Millisecond,
+		MaxBackoff:     10 * time.Second,
+		Multiplier:     2,
+		Jitter:         0.2,
+	}
+}
+
+// retryableResultCodes lists LDAP result codes indicating a transient condition on the server or network
+var retryableResultCodes = []uint16{
+	ldap.LDAPResultBusy,
+	ldap.LDAPResultUnavailable,
+	ldap.LDAPResultServerDown,
+	ldap.LDAPResultTimeout,
+	ldap.LDAPResultConnectError,
+	ldap.ErrorNetwork,
+}
+
+// isRetryable decides whether an error returned by an LDAP operation is worth another attempt
+func isRetryable(err error) bool {
+	if err == nil {
+		return false
+	}
+
+	// Check for transient LDAP result codes
+	if ldap.IsErrorAnyOf(err, retryableResultCodes...) {
+		return true
+	}
+
+	// Check for connection resets and network timeouts, which might not be wrapped into an LDAP error
+	if errors.Is(err, syscall.ECONNRESET) || errors.Is(err, syscall.ECONNREFUSED) {
+		return true
+	}
+	var netErr net.Error
+	if errors.As(err, &netErr) && netErr.Timeout() {
+		return true
+	}
+
+	// Return false as error is permanent
+	return false
+}
+
+// backoff calculates the delay before the given retry attempt (starting at 1), including jitter
+func (r *RetryOptions) backoff(attempt int) time.Duration {
+
+	// Calculate exponential delay
+	delay := float64(r.InitialBackoff)
+	multiplier := r.Multiplier
+	if multiplier < 1 {
+		multiplier = 1
+	}
+	for i := 1; i < attempt; i++ {
+		delay *= multiplier
+		if r.MaxBackoff > 0 && delay > float64(r.MaxBackoff) {
+			delay = float64(r.MaxBackoff)
+			break
+		}
+	}
+
+	// Randomize delay within +/- jitter
+	if r.Jitter > 0 {
+		delay += delay * r.Jitter * (2*rand.Float64() - 1)
+	}
+	if delay < 0 {
+		delay = 0
+	}
+
+	// Return delay
+	return time.Duration(delay)
+}
+
+// withRetry executes the given operation and repeats it according to the retry policy, as long as it fails with
+// a retryable error. A nil policy executes the operation exactly once.
+func withRetry(logger utils.Logger, retryOptions *RetryOptions, operation string, fn func() error) error {
+
+	// Execute once, if no retry policy is set
+	if retryOptions == nil || retryOptions.MaxAttempts <= 1 {
+		return fn()
+	}
+
+	// Execute until success, permanent error or exhausted attempts
+	var err error
+	for attempt := 1; attempt <= retryOptions.MaxAttempts; attempt++ {
+		err = fn()
+		if err == nil {
+			return nil
+		}
+		if !isRetryable(err) {
+			return err
+		}
+		if attempt == retryOptions.MaxAttempts {
+			break
+		}
+
+		// Wait before next attempt
+		delay := retryOptions.backoff(attempt)
+		logger.Debugf(
+			"%s failed with transient error (attempt %d/%d), retrying in %s: %s",
+			operation, attempt, retryOptions.MaxAttempts, delay, err)
+		time.Sleep(delay)
+	}
+
+	// Return last error
+	logger.Debugf("%s failed after %d attempts: %s", operation, retryOptions.MaxAttempts, err)
+	return err
+}
+
+// search executes an LDAP search request according to the retry policy. If the connection got lost during an
+// attempt (e.g. by a network reset), the session is re-established before the next one.
+func (s *LdapSession) search(logger utils.Logger, searchRequest *ldap.SearchRequest) (*ldap.SearchResult, error) {
+	return s.searchWithRetry(logger, "LDAP search", func(conn *ldap.Conn) (*ldap.SearchResult, error) {
+		return conn.Search(searchRequest)
+	})
+}
+
+// ldapPageSize is the number of entries requested per page by paged searches, matching the default MaxPageSize
+// of Active Directory
+const ldapPageSize = 1000
+
+// searchPaged executes an LDAP search with the paged results control according to the retry policy, in order to
+// retrieve result sets exceeding the server's size limit. Retries restart the search from the first page.
+func (s *LdapSession) searchPaged(logger utils.Logger, searchRequest *ldap.SearchRequest) (*ldap.SearchResult, error) {
+	return s.searchWithRetry(logger, "LDAP paged search", func(conn *ldap.Conn) (*ldap.SearchResult, error) {
+		attempt := *searchRequest
+		attempt.Controls = append([]ldap.Control{}, searchRequest.Controls...)
+		return conn.SearchWithPaging(&attempt, ldapPageSize)
+	})
+}
+
+// searchWithRetry executes a search on the session's connection according to the retry policy, reconnecting first
+// if the connection was lost
+func (s *LdapSession) searchWithRetry(
+	logger utils.Logger,
+	operation string,
+	search func(conn *ldap.Conn) (*ldap.SearchResult, error),
+) (*ldap.SearchResult, error) {
+	var result *ldap.SearchResult
+	err := withRetry(logger, s.Options.Retry, operation, func() error {
+
+		// Re-establish session, a closing connection would fail again right away
+		if s.Conn.IsClosing() {
+			errReconnect := s.reconnect(logger)
+			if errReconnect != nil {
+				return errReconnect
+			}
+		}
+
+		// Execute search
+		var errSearch error
+		result, errSearch = search(s.Conn)
+		return errSearch
+	})
+	return result, err
+}
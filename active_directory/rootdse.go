@@ -0,0 +1,83 @@
+package active_directory
+
+import (
+	"fmt"
+
+	"github.com/go-ldap/ldap/v3"
+	"github.com/siemens/GoScans/utils"
+)
+
+// Domain and forest functional levels as reported by the rootDSE
+const (
+	FunctionalLevel2000      = 0
+	FunctionalLevel2003Mixed = 1
+	FunctionalLevel2003      = 2
+	FunctionalLevel2008      = 3
+	FunctionalLevel2008R2    = 4
+	FunctionalLevel2012      = 5
+	FunctionalLevel2012R2    = 6
+	FunctionalLevel2016      = 7
+	FunctionalLevel2025      = 10
+)
+
+// RootDse holds the information published by the server's rootDSE, which is readable without authentication
+type RootDse struct {
+	DefaultNamingContext          string   `ldap:"defaultNamingContext"`
+	ConfigurationNamingContext    string   `ldap:"configurationNamingContext"`
+	SchemaNamingContext           string   `ldap:"schemaNamingContext"`
+	RootDomainNamingContext       string   `ldap:"rootDomainNamingContext"`
+	NamingContexts                []string `ldap:"namingContexts"`
+	DnsHostName                   string   `ldap:"dnsHostName"`
+	ServerName                    string   `ldap:"serverName"`
+	SupportedControls             []string `ldap:"supportedControl"`
+	SupportedSaslMechanisms       []string `ldap:"supportedSASLMechanisms"`
+	DomainFunctionality           int      `ldap:"domainFunctionality"`
+	ForestFunctionality           int      `ldap:"forestFunctionality"`
+	DomainControllerFunctionality int      `ldap:"domainControllerFunctionality"`
+}
+
+// readRootDse reads the rootDSE of the session's server
+func readRootDse(logger utils.Logger, session *LdapSession) (*RootDse, error) {
+
+	// Prepare search
+	rootDseSearch := ldap.NewSearchRequest(
+		"", // The rootDSE has an empty DN
+		ldap.ScopeBaseObject,
+		ldap.NeverDerefAliases,
+		0,
+		0,
+		false,
+		"(objectClass=*)",     // The filter to apply
+		Attributes(RootDse{}), // Operational attributes are only returned if requested explicitly
+		nil,
+	)
+
+	// Execute search
+	rootDseResult, errSearch := session.search(logger, rootDseSearch)
+	if errSearch != nil {
+		return nil, errSearch
+	}
+	if len(rootDseResult.Entries) != 1 {
+		return nil, fmt.Errorf("rootDSE search returned %d entries", len(rootDseResult.Entries))
+	}
+
+	// Decode rootDSE
+	rootDse := &RootDse{}
+	errDecode := Unmarshal(rootDseResult.Entries[0], rootDse)
+	if errDecode != nil {
+		logger.Errorf("Could not decode rootDSE: %s", errDecode)
+	}
+
+	// Return rootDSE
+	return rootDse, nil
+}
+
+// SupportsControl returns whether the server announced support for the control with the given OID
+func (r *RootDse) SupportsControl(oid string) bool {
+	for _, supported := range r.SupportedControls {
+		if supported == oid {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,507 @@
+package active_directory
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"net"
+	"strconv"
+	"sync"
+
+	"github.com/go-ldap/ldap/v3"
+	"github.com/jcmturner/gokrb5/v8/client"
+	"github.com/jcmturner/gokrb5/v8/crypto"
+	"github.com/jcmturner/gokrb5/v8/gssapi"
+	"github.com/jcmturner/gokrb5/v8/iana/etypeID"
+	"github.com/jcmturner/gokrb5/v8/iana/keyusage"
+	"github.com/jcmturner/gokrb5/v8/messages"
+	"github.com/jcmturner/gokrb5/v8/spnego"
+	"github.com/jcmturner/gokrb5/v8/types"
+	"github.com/siemens/GoScans/utils"
+)
+
+// SaslSecurityLayer defines the protection applied to LDAP messages after a successful SASL GSSAPI bind
+type SaslSecurityLayer int
+
+const (
+	SaslSecurityLayerNone            SaslSecurityLayer = iota // No protection, LDAP messages are sent as they are
+	SaslSecurityLayerIntegrity                                // LDAP messages are signed (LDAP signing)
+	SaslSecurityLayerConfidentiality                          // LDAP messages are signed and encrypted (LDAP sealing)
+)
+
+// SASL security layer bits as exchanged during the final GSSAPI handshake step, see RFC 4752 section 3.3
+const (
+	saslLayerBitNone            = 0x01
+	saslLayerBitIntegrity       = 0x02
+	saslLayerBitConfidentiality = 0x04
+)
+
+// saslMaxReceiveSize is the maximum size of a wrapped message we are willing to accept
+const saslMaxReceiveSize = 0xFFFFFF
+
+// Wrap token flags, see RFC 4121 section 4.2.2
+const (
+	wrapFlagSentByAcceptor = 0x01
+	wrapFlagSealed         = 0x02
+	wrapFlagAcceptorSubkey = 0x04
+)
+
+func (l SaslSecurityLayer) String() string {
+	switch l {
+	case SaslSecurityLayerNone:
+		return "none"
+	case SaslSecurityLayerIntegrity:
+		return "integrity"
+	case SaslSecurityLayerConfidentiality:
+		return "confidentiality"
+	default:
+		return fmt.Sprintf("SaslSecurityLayer(%d)", int(l))
+	}
+}
+
+// bit returns the SASL security layer bit of the layer
+func (l SaslSecurityLayer) bit() byte {
+	switch l {
+	case SaslSecurityLayerIntegrity:
+		return saslLayerBitIntegrity
+	case SaslSecurityLayerConfidentiality:
+		return saslLayerBitConfidentiality
+	default:
+		return saslLayerBitNone
+	}
+}
+
+// saslSecurityLayer protects and unprotects single LDAP messages. It is implemented by the Kerberos security
+// context and can be replaced by a local stand-in to exercise the wrapping connection without a KDC.
+type saslSecurityLayer interface {
+	wrap(payload []byte) ([]byte, error)
+	unwrap(token []byte) ([]byte, error)
+}
+
+// gssapiLayerClient implements the ldap.GSSAPIClient interface like gssapi.Client from go-ldap, but negotiates
+// the requested SASL security layer instead of always refusing one. After a successful bind, the established
+// security context is kept in context.
+type gssapiLayerClient struct {
+	krbClient *client.Client
+	layer     SaslSecurityLayer
+
+	sessionKey      types.EncryptionKey // Key of the service ticket
+	initiatorSubkey types.EncryptionKey // Initiator subkey, as sent in the authenticator
+	subkey          types.EncryptionKey // Acceptor subkey, if sent with the AP-REP
+	seqNum          uint64              // Initial initiator sequence number, as sent in the authenticator
+	context         *gssapiSecurityContext
+}
+
+// InitSecContext initiates the establishment of a security context, see RFC 4752 section 3.1
+func (c *gssapiLayerClient) InitSecContext(target string, input []byte) ([]byte, bool, error) {
+	return c.InitSecContextWithOptions(target, input, []int{})
+}
+
+// InitSecContextWithOptions initiates the establishment of a security context, see RFC 4752 section 3.1
+func (c *gssapiLayerClient) InitSecContextWithOptions(target string, input []byte, apOptions []int) ([]byte, bool, error) {
+	gssapiFlags := []int{gssapi.ContextFlagInteg, gssapi.ContextFlagConf, gssapi.ContextFlagMutual}
+
+	// Request service ticket and build AP-REQ in the first step
+	if input == nil {
+		tkt, sessionKey, err := c.krbClient.GetServiceTicket(target)
+		if err != nil {
+			return nil, false, err
+		}
+		c.sessionKey = sessionKey
+
+		token, err := spnego.NewKRB5TokenAPREQ(c.krbClient, tkt, sessionKey, gssapiFlags, apOptions)
+		if err != nil {
+			return nil, false, err
+		}
+
+		// Remember the initial sequence number and the initiator subkey, which are only contained in the encrypted
+		// authenticator
+		err = token.APReq.DecryptAuthenticator(sessionKey)
+		if err != nil {
+			return nil, false, err
+		}
+		c.seqNum = uint64(token.APReq.Authenticator.SeqNumber)
+		c.initiatorSubkey = token.APReq.Authenticator.SubKey
+
+		output, err := token.Marshal()
+		if err != nil {
+			return nil, false, err
+		}
+		return output, true, nil
+	}
+
+	// Process AP-REP in the second step
+	var token spnego.KRB5Token
+	err := token.Unmarshal(input)
+	if err != nil {
+		return nil, false, err
+	}
+	if token.IsKRBError() {
+		return nil, true, token.KRBError
+	}
+	if !token.IsAPRep() {
+		return make([]byte, 0), true, nil
+	}
+	encPart, err := crypto.DecryptEncPart(token.APRep.EncPart, c.sessionKey, keyusage.AP_REP_ENCPART)
+	if err != nil {
+		return nil, false, err
+	}
+	part := &messages.EncAPRepPart{}
+	err = part.Unmarshal(encPart)
+	if err != nil {
+		return nil, false, err
+	}
+	c.subkey = part.Subkey
+	return make([]byte, 0), false, nil
+}
+
+// NegotiateSaslAuth performs the last step of the SASL handshake and selects the security layer,
+// see RFC 4752 section 3.1
+func (c *gssapiLayerClient) NegotiateSaslAuth(input []byte, authzid string) ([]byte, error) {
+
+	// Prepare security context with the keys of the handshake
+	ctx, err := newGssapiSecurityContext(c.sessionKey, c.initiatorSubkey, c.subkey, c.seqNum, c.layer)
+	if err != nil {
+		return nil, err
+	}
+
+	// Read the layers offered by the server. The offer itself is always integrity protected only.
+	offer, err := ctx.unwrap(input)
+	if err != nil {
+		return nil, err
+	}
+	if len(offer) != 4 {
+		return nil, fmt.Errorf("server sent bad final token for SASL GSSAPI handshake")
+	}
+	if offer[0]&c.layer.bit() == 0 {
+		return nil, fmt.Errorf("server does not offer SASL security layer '%s' (offered 0x%02x)", c.layer, offer[0])
+	}
+	serverMaxSize := uint32(offer[1])<<16 | uint32(offer[2])<<8 | uint32(offer[3])
+	if c.layer != SaslSecurityLayerNone {
+		ctx.maxSendSize = int(serverMaxSize)
+	}
+
+	// Answer with the selected layer and our maximum receive size
+	answer := []byte{c.layer.bit(), 0, 0, 0}
+	if c.layer != SaslSecurityLayerNone {
+		maxSize := uint32(saslMaxReceiveSize)
+		answer[1], answer[2], answer[3] = byte(maxSize>>16), byte(maxSize>>8), byte(maxSize)
+	}
+	answer = append(answer, []byte(authzid)...)
+	output, err := ctx.wrapWith(answer, false)
+	if err != nil {
+		return nil, err
+	}
+
+	// Keep the security context for the subsequent LDAP messages
+	c.context = ctx
+	return output, nil
+}
+
+// DeleteSecContext destroys the handshake state. The negotiated security context is kept.
+func (c *gssapiLayerClient) DeleteSecContext() error {
+	c.sessionKey = types.EncryptionKey{}
+	c.initiatorSubkey = types.EncryptionKey{}
+	c.subkey = types.EncryptionKey{}
+	return nil
+}
+
+// gssapiSecurityContext implements the RFC 4121 wrap tokens used as SASL security layer
+type gssapiSecurityContext struct {
+	key         types.EncryptionKey
+	flags       byte // Flags of the tokens sent by us
+	sealed      bool
+	sendSeqNum  uint64
+	maxSendSize int
+}
+
+// newGssapiSecurityContext prepares a security context from the keys negotiated during the handshake
+func newGssapiSecurityContext(
+	sessionKey types.EncryptionKey,
+	initiatorSubkey types.EncryptionKey,
+	acceptorSubkey types.EncryptionKey,
+	seqNum uint64,
+	layer SaslSecurityLayer,
+) (*gssapiSecurityContext, error) {
+
+	// Prefer the acceptor subkey, if one was sent, then the initiator subkey, see RFC 4121 section 2
+	ctx := &gssapiSecurityContext{
+		key:        sessionKey,
+		sealed:     layer == SaslSecurityLayerConfidentiality,
+		sendSeqNum: seqNum,
+	}
+	if len(acceptorSubkey.KeyValue) > 0 {
+		ctx.key = acceptorSubkey
+		ctx.flags |= wrapFlagAcceptorSubkey
+	} else if len(initiatorSubkey.KeyValue) > 0 {
+		ctx.key = initiatorSubkey
+	}
+
+	// RFC 4121 tokens are only defined for the newer encryption types
+	switch ctx.key.KeyType {
+	case etypeID.RC4_HMAC, etypeID.RC4_HMAC_EXP, etypeID.DES3_CBC_SHA1_KD:
+		if layer != SaslSecurityLayerNone {
+			return nil, fmt.Errorf("SASL security layer not supported with encryption type %d", ctx.key.KeyType)
+		}
+	}
+
+	// Return security context
+	return ctx, nil
+}
+
+// wrap protects an outgoing LDAP message
+func (ctx *gssapiSecurityContext) wrap(payload []byte) ([]byte, error) {
+	if ctx.maxSendSize > 0 && len(payload) > ctx.maxSendSize {
+		return nil, fmt.Errorf("message of %d bytes exceeds server receive limit of %d bytes", len(payload), ctx.maxSendSize)
+	}
+	return ctx.wrapWith(payload, ctx.sealed)
+}
+
+// wrapWith builds a wrap token, optionally encrypting the payload, see RFC 4121 section 4.2.4
+func (ctx *gssapiSecurityContext) wrapWith(payload []byte, sealed bool) ([]byte, error) {
+	defer func() { ctx.sendSeqNum++ }()
+
+	encType, err := crypto.GetEtype(ctx.key.KeyType)
+	if err != nil {
+		return nil, err
+	}
+
+	// Integrity protected tokens carry the plain payload followed by a checksum
+	if !sealed {
+		token := gssapi.WrapToken{
+			Flags:     ctx.flags,
+			EC:        uint16(encType.GetHMACBitLength() / 8),
+			SndSeqNum: ctx.sendSeqNum,
+			Payload:   payload,
+		}
+		err = token.SetCheckSum(ctx.key, keyusage.GSSAPI_INITIATOR_SEAL)
+		if err != nil {
+			return nil, err
+		}
+		return token.Marshal()
+	}
+
+	// Confidentiality protected tokens carry the encrypted payload followed by an encrypted copy of the header
+	header := wrapTokenHeader(ctx.flags|wrapFlagSealed, 0, 0, ctx.sendSeqNum)
+	plain := make([]byte, 0, len(payload)+gssapi.HdrLen)
+	plain = append(plain, payload...)
+	plain = append(plain, header...)
+	_, cipher, err := encType.EncryptMessage(ctx.key.KeyValue, plain, keyusage.GSSAPI_INITIATOR_SEAL)
+	if err != nil {
+		return nil, err
+	}
+	return append(header, cipher...), nil
+}
+
+// unwrap verifies and, if necessary, decrypts an incoming wrap token
+func (ctx *gssapiSecurityContext) unwrap(token []byte) ([]byte, error) {
+
+	// Parse header
+	if len(token) < gssapi.HdrLen {
+		return nil, errors.New("wrap token shorter than header")
+	}
+	if token[0] != 0x05 || token[1] != 0x04 || token[3] != gssapi.FillerByte {
+		return nil, errors.New("invalid wrap token header")
+	}
+	flags := token[2]
+	if flags&wrapFlagSentByAcceptor == 0 {
+		return nil, errors.New("wrap token not sent by acceptor")
+	}
+	ec := int(binary.BigEndian.Uint16(token[4:6]))
+	rrc := int(binary.BigEndian.Uint16(token[6:8]))
+	seqNum := binary.BigEndian.Uint64(token[8:16])
+
+	// Select key, the acceptor might have asserted its subkey
+	key := ctx.key
+	if flags&wrapFlagAcceptorSubkey == 0 && ctx.flags&wrapFlagAcceptorSubkey != 0 {
+		return nil, errors.New("wrap token not protected with acceptor subkey")
+	}
+
+	// Undo the right rotation of the data following the header
+	data := rotateLeft(token[gssapi.HdrLen:], rrc)
+
+	encType, err := crypto.GetEtype(key.KeyType)
+	if err != nil {
+		return nil, err
+	}
+
+	// Decrypt sealed tokens. The plaintext is followed by EC filler bytes and a copy of the header.
+	if flags&wrapFlagSealed != 0 {
+		plain, errDecrypt := encType.DecryptMessage(key.KeyValue, data, keyusage.GSSAPI_ACCEPTOR_SEAL)
+		if errDecrypt != nil {
+			return nil, errDecrypt
+		}
+		if len(plain) < ec+gssapi.HdrLen {
+			return nil, errors.New("decrypted wrap token too short")
+		}
+		return plain[:len(plain)-ec-gssapi.HdrLen], nil
+	}
+
+	// Verify checksum of integrity protected tokens
+	if ec > len(data) {
+		return nil, errors.New("inconsistent wrap token checksum length")
+	}
+	wrapToken := gssapi.WrapToken{
+		Flags:     flags,
+		EC:        uint16(ec),
+		SndSeqNum: seqNum,
+		Payload:   data[:len(data)-ec],
+		CheckSum:  data[len(data)-ec:],
+	}
+	_, err = wrapToken.Verify(key, keyusage.GSSAPI_ACCEPTOR_SEAL)
+	if err != nil {
+		return nil, err
+	}
+	return wrapToken.Payload, nil
+}
+
+// wrapTokenHeader builds the 16 byte header of a wrap token
+func wrapTokenHeader(flags byte, ec uint16, rrc uint16, seqNum uint64) []byte {
+	header := make([]byte, gssapi.HdrLen)
+	header[0], header[1], header[2], header[3] = 0x05, 0x04, flags, gssapi.FillerByte
+	binary.BigEndian.PutUint16(header[4:6], ec)
+	binary.BigEndian.PutUint16(header[6:8], rrc)
+	binary.BigEndian.PutUint64(header[8:16], seqNum)
+	return header
+}
+
+// rotateLeft reverts a right rotation by the given count
+func rotateLeft(data []byte, count int) []byte {
+	if len(data) == 0 {
+		return data
+	}
+	count %= len(data)
+	if count == 0 {
+		return data
+	}
+	rotated := make([]byte, 0, len(data))
+	rotated = append(rotated, data[count:]...)
+	return append(rotated, data[:count]...)
+}
+
+// saslConn wraps a network connection and applies a SASL security layer once it is enabled. Before that, data
+// is passed through unchanged, so that the bind itself can happen on the same connection. Every SASL buffer is
+// prefixed with its length as a four byte big endian integer, see RFC 4422 section 3.7.
+type saslConn struct {
+	net.Conn
+
+	mutex   sync.Mutex
+	layer   saslSecurityLayer
+	inbound []byte // Received, not yet unwrapped data
+	plain   []byte // Unwrapped data not yet returned to the reader
+}
+
+// enable activates the security layer for all subsequent reads and writes
+func (c *saslConn) enable(layer saslSecurityLayer) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	c.layer = layer
+}
+
+// Read returns unwrapped data. The layer is checked only after data arrived, because the LDAP reader is already
+// waiting for the next message while the bind completes. The server does not send anything before our first
+// protected request, so all data arriving after enabling the layer is protected.
+func (c *saslConn) Read(b []byte) (int, error) {
+	for {
+		c.mutex.Lock()
+		if len(c.plain) > 0 {
+			n := copy(b, c.plain)
+			c.plain = c.plain[n:]
+			c.mutex.Unlock()
+			return n, nil
+		}
+		layer := c.layer
+		c.mutex.Unlock()
+
+		// Read from the network, directly into the caller's buffer as long as there is no layer
+		buf := b
+		if layer != nil {
+			buf = make([]byte, 32*1024)
+		}
+		n, err := c.Conn.Read(buf)
+
+		// Pass data through if there is still no layer, otherwise the data read is protected
+		c.mutex.Lock()
+		if c.layer == nil {
+			c.mutex.Unlock()
+			return n, err
+		}
+
+		// Unwrap all complete buffers
+		c.inbound = append(c.inbound, buf[:n]...)
+		for len(c.inbound) >= 4 {
+			size := int(binary.BigEndian.Uint32(c.inbound[:4]))
+			if size > saslMaxReceiveSize {
+				c.mutex.Unlock()
+				return 0, fmt.Errorf("SASL buffer of %d bytes exceeds receive limit", size)
+			}
+			if len(c.inbound) < 4+size {
+				break
+			}
+			payload, errUnwrap := c.layer.unwrap(c.inbound[4 : 4+size])
+			if errUnwrap != nil {
+				c.mutex.Unlock()
+				return 0, fmt.Errorf("could not unwrap SASL buffer: %w", errUnwrap)
+			}
+			c.plain = append(c.plain, payload...)
+			c.inbound = c.inbound[4+size:]
+		}
+		pending := len(c.plain)
+		c.mutex.Unlock()
+
+		// Return network errors only when there is no more data to deliver
+		if err != nil && pending == 0 {
+			return 0, err
+		}
+	}
+}
+
+// Write wraps a single LDAP message, go-ldap writes each message with a single call
+func (c *saslConn) Write(b []byte) (int, error) {
+	c.mutex.Lock()
+	layer := c.layer
+	c.mutex.Unlock()
+	if layer == nil {
+		return c.Conn.Write(b)
+	}
+
+	// Wrap message and prefix it with its length
+	token, err := layer.wrap(b)
+	if err != nil {
+		return 0, err
+	}
+	buf := make([]byte, 4, 4+len(token))
+	binary.BigEndian.PutUint32(buf, uint32(len(token)))
+	buf = append(buf, token...)
+	_, err = c.Conn.Write(buf)
+	if err != nil {
+		return 0, err
+	}
+	return len(b), nil
+}
+
+// ldapOpenSasl opens a plain LDAP connection prepared for a SASL security layer, which is enabled on the returned
+// saslConn after the bind. The connection is not yet bound.
+func ldapOpenSasl(logger utils.Logger, ldapAddress string, options LdapOptions) (*ldap.Conn, *saslConn, error) {
+
+	// Security layers are negotiated on plain connections only, Active Directory refuses them within TLS
+	if options.TLS != nil && options.TLS.Mode != TransportPlain {
+		return nil, nil, fmt.Errorf("SASL security layer cannot be combined with transport mode '%s'", options.TLS.Mode)
+	}
+
+	// Open network connection
+	address := net.JoinHostPort(ldapHost(ldapAddress), strconv.Itoa(options.port()))
+	dialer := &net.Dialer{Timeout: options.DialTimeout}
+	netConn, errDial := dialer.Dial("tcp", address)
+	if errDial != nil {
+		return nil, nil, errDial
+	}
+
+	// Start LDAP connection on top of the wrapping connection
+	sc := &saslConn{Conn: netConn}
+	conn := ldap.NewConn(sc, false)
+	conn.Start()
+
+	// Return connection
+	return conn, sc, nil
+}
@@ -0,0 +1,367 @@
+package active_directory
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"io"
+	"net"
+	"testing"
+
+	"github.com/jcmturner/gokrb5/v8/crypto"
+	"github.com/jcmturner/gokrb5/v8/gssapi"
+	"github.com/jcmturner/gokrb5/v8/iana/etypeID"
+	"github.com/jcmturner/gokrb5/v8/iana/keyusage"
+	"github.com/jcmturner/gokrb5/v8/types"
+)
+
+// xorLayer is a stand-in security layer, which marks wrapped tokens with a prefix and obfuscates their payload
+type xorLayer struct {
+	key byte
+}
+
+func (l *xorLayer) wrap(payload []byte) ([]byte, error) {
+	token := []byte{'W'}
+	for _, b := range payload {
+		token = append(token, b^l.key)
+	}
+	return token, nil
+}
+
+func (l *xorLayer) unwrap(token []byte) ([]byte, error) {
+	if len(token) == 0 || token[0] != 'W' {
+		return nil, errors.New("not a wrapped token")
+	}
+	payload := make([]byte, 0, len(token)-1)
+	for _, b := range token[1:] {
+		payload = append(payload, b^l.key)
+	}
+	return payload, nil
+}
+
+// frame wraps a payload with the stand-in layer and prefixes it with its length, like the server would
+func frame(t *testing.T, layer saslSecurityLayer, payload []byte) []byte {
+	t.Helper()
+	token, err := layer.wrap(payload)
+	if err != nil {
+		t.Fatalf("wrap failed: %s", err)
+	}
+	buf := make([]byte, 4, 4+len(token))
+	binary.BigEndian.PutUint32(buf, uint32(len(token)))
+	return append(buf, token...)
+}
+
+// readAll reads the given number of bytes from the connection using small buffers
+func readAll(t *testing.T, conn net.Conn, size int, chunk int) []byte {
+	t.Helper()
+	var received []byte
+	buf := make([]byte, chunk)
+	for len(received) < size {
+		n, err := conn.Read(buf)
+		if err != nil {
+			t.Fatalf("read failed after %d bytes: %s", len(received), err)
+		}
+		received = append(received, buf[:n]...)
+	}
+	return received
+}
+
+func TestSaslConnPassThrough(t *testing.T) {
+	client, server := net.Pipe()
+	defer func() { _ = client.Close() }()
+	defer func() { _ = server.Close() }()
+	conn := &saslConn{Conn: client}
+
+	// Data sent before the layer is enabled must arrive unchanged, even if read with short buffers
+	sent := bytes.Repeat([]byte("0123456789"), 10)
+	go func() { _, _ = server.Write(sent) }()
+	received := readAll(t, conn, len(sent), 7)
+	if !bytes.Equal(received, sent) {
+		t.Errorf("received %q, want %q", received, sent)
+	}
+
+	// Writes are passed through as well
+	go func() { _, _ = conn.Write([]byte("bind")) }()
+	raw := readAll(t, server, 4, 16)
+	if string(raw) != "bind" {
+		t.Errorf("server received %q, want %q", raw, "bind")
+	}
+}
+
+func TestSaslConnWrap(t *testing.T) {
+	client, server := net.Pipe()
+	defer func() { _ = client.Close() }()
+	defer func() { _ = server.Close() }()
+	layer := &xorLayer{key: 0x5a}
+	conn := &saslConn{Conn: client}
+	conn.enable(layer)
+
+	// Each write must be sent as a single length prefixed token
+	message := []byte("search request")
+	go func() { _, _ = conn.Write(message) }()
+	header := readAll(t, server, 4, 4)
+	size := int(binary.BigEndian.Uint32(header))
+	token := readAll(t, server, size, size)
+	payload, err := layer.unwrap(token)
+	if err != nil {
+		t.Fatalf("server could not unwrap token: %s", err)
+	}
+	if !bytes.Equal(payload, message) {
+		t.Errorf("server unwrapped %q, want %q", payload, message)
+	}
+}
+
+func TestSaslConnUnwrap(t *testing.T) {
+	client, server := net.Pipe()
+	defer func() { _ = client.Close() }()
+	defer func() { _ = server.Close() }()
+	layer := &xorLayer{key: 0x5a}
+	conn := &saslConn{Conn: client}
+	conn.enable(layer)
+
+	// Send two tokens in one write and a third one split across writes
+	first := frame(t, layer, []byte("first "))
+	second := frame(t, layer, []byte("second "))
+	third := frame(t, layer, []byte("third"))
+	go func() {
+		_, _ = server.Write(append(append([]byte{}, first...), second...))
+		_, _ = server.Write(third[:3])
+		_, _ = server.Write(third[3:])
+	}()
+
+	// The plain data must be returned in order, regardless of the framing
+	want := []byte("first second third")
+	received := readAll(t, conn, len(want), 5)
+	if !bytes.Equal(received, want) {
+		t.Errorf("received %q, want %q", received, want)
+	}
+}
+
+func TestSaslConnErrors(t *testing.T) {
+	tests := []struct {
+		name string
+		data []byte
+	}{
+		{"oversized token", []byte{0x01, 0x00, 0x00, 0x00}},
+		{"invalid token", []byte{0x00, 0x00, 0x00, 0x02, 'X', 'Y'}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			client, server := net.Pipe()
+			defer func() { _ = client.Close() }()
+			defer func() { _ = server.Close() }()
+			conn := &saslConn{Conn: client}
+			conn.enable(&xorLayer{key: 0x5a})
+
+			go func() { _, _ = server.Write(tt.data) }()
+			_, err := conn.Read(make([]byte, 16))
+			if err == nil || errors.Is(err, io.EOF) {
+				t.Errorf("expected error, got %v", err)
+			}
+		})
+	}
+}
+
+// testKey is a fixed AES256 key, as negotiated during a Kerberos handshake
+var testKey = types.EncryptionKey{
+	KeyType:  etypeID.AES256_CTS_HMAC_SHA1_96,
+	KeyValue: bytes.Repeat([]byte{0x42}, 32),
+}
+
+// rotateRight rotates the data right by the given count, like the acceptor does according to the RRC
+func rotateRight(data []byte, count int) []byte {
+	count %= len(data)
+	rotated := make([]byte, 0, len(data))
+	rotated = append(rotated, data[len(data)-count:]...)
+	return append(rotated, data[:len(data)-count]...)
+}
+
+// acceptorToken builds a wrap token as sent by the acceptor, see RFC 4121 section 4.2.4
+func acceptorToken(t *testing.T, flags byte, sealed bool, ec int, rrc int, seqNum uint64, payload []byte) []byte {
+	t.Helper()
+	flags |= wrapFlagSentByAcceptor
+
+	// Integrity protected tokens carry the plain payload followed by a checksum
+	var header, data []byte
+	if !sealed {
+		token := gssapi.WrapToken{Flags: flags, EC: 12, SndSeqNum: seqNum, Payload: payload}
+		if err := token.SetCheckSum(testKey, keyusage.GSSAPI_ACCEPTOR_SEAL); err != nil {
+			t.Fatalf("could not compute checksum: %s", err)
+		}
+		marshalled, err := token.Marshal()
+		if err != nil {
+			t.Fatalf("could not marshal token: %s", err)
+		}
+		header, data = marshalled[:gssapi.HdrLen], marshalled[gssapi.HdrLen:]
+	} else {
+
+		// Sealed tokens carry the encrypted payload, EC filler bytes and a copy of the header
+		flags |= wrapFlagSealed
+		header = wrapTokenHeader(flags, uint16(ec), 0, seqNum)
+		plain := append(append(append([]byte{}, payload...), bytes.Repeat([]byte{0xff}, ec)...), header...)
+		encType, err := crypto.GetEtype(testKey.KeyType)
+		if err != nil {
+			t.Fatalf("could not get encryption type: %s", err)
+		}
+		_, data, err = encType.EncryptMessage(testKey.KeyValue, plain, keyusage.GSSAPI_ACCEPTOR_SEAL)
+		if err != nil {
+			t.Fatalf("could not encrypt token: %s", err)
+		}
+	}
+
+	// Rotate the data following the header and announce it in the header
+	header = append([]byte{}, header...)
+	binary.BigEndian.PutUint16(header[6:8], uint16(rrc))
+	return append(header, rotateRight(data, rrc)...)
+}
+
+func TestGssapiSecurityContextUnwrap(t *testing.T) {
+	payload := []byte("search result")
+	tests := []struct {
+		name           string
+		acceptorSubkey bool
+		flags          byte
+		sealed         bool
+		ec             int
+		rrc            int
+	}{
+		{"integrity", false, 0, false, 0, 0},
+		{"integrity rotated", false, 0, false, 0, 5},
+		{"integrity with acceptor subkey", true, wrapFlagAcceptorSubkey, false, 0, 0},
+		{"sealed", false, 0, true, 0, 0},
+		{"sealed with filler", false, 0, true, 16, 0},
+		{"sealed rotated", false, 0, true, 0, 28},
+		{"sealed with filler rotated", false, 0, true, 16, 44},
+		{"sealed with acceptor subkey", true, wrapFlagAcceptorSubkey, true, 0, 28},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var acceptorSubkey types.EncryptionKey
+			if tt.acceptorSubkey {
+				acceptorSubkey = testKey
+			}
+			ctx, err := newGssapiSecurityContext(
+				testKey, types.EncryptionKey{}, acceptorSubkey, 0, SaslSecurityLayerConfidentiality)
+			if err != nil {
+				t.Fatalf("could not create security context: %s", err)
+			}
+			token := acceptorToken(t, tt.flags, tt.sealed, tt.ec, tt.rrc, 7, payload)
+			got, err := ctx.unwrap(token)
+			if err != nil {
+				t.Fatalf("unwrap failed: %s", err)
+			}
+			if !bytes.Equal(got, payload) {
+				t.Errorf("unwrap returned %q, want %q", got, payload)
+			}
+		})
+	}
+}
+
+func TestGssapiSecurityContextUnwrapErrors(t *testing.T) {
+	payload := []byte("search result")
+	tamper := func(token []byte) []byte {
+		token = append([]byte{}, token...)
+		token[len(token)-1] ^= 0x01
+		return token
+	}
+	tests := []struct {
+		name           string
+		acceptorSubkey bool
+		token          func(t *testing.T) []byte
+	}{
+		{"bad checksum", false, func(t *testing.T) []byte {
+			return tamper(acceptorToken(t, 0, false, 0, 0, 0, payload))
+		}},
+		{"bad ciphertext", false, func(t *testing.T) []byte {
+			return tamper(acceptorToken(t, 0, true, 0, 0, 0, payload))
+		}},
+		{"missing acceptor subkey flag", true, func(t *testing.T) []byte {
+			return acceptorToken(t, 0, false, 0, 0, 0, payload)
+		}},
+		{"sent by initiator", false, func(t *testing.T) []byte {
+			token := acceptorToken(t, 0, false, 0, 0, 0, payload)
+			token[2] &^= wrapFlagSentByAcceptor
+			return token
+		}},
+		{"short header", false, func(t *testing.T) []byte {
+			return []byte{0x05, 0x04, wrapFlagSentByAcceptor, 0xff}
+		}},
+		{"invalid token id", false, func(t *testing.T) []byte {
+			token := acceptorToken(t, 0, false, 0, 0, 0, payload)
+			token[0] = 0x04
+			return token
+		}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var acceptorSubkey types.EncryptionKey
+			if tt.acceptorSubkey {
+				acceptorSubkey = testKey
+			}
+			ctx, err := newGssapiSecurityContext(
+				testKey, types.EncryptionKey{}, acceptorSubkey, 0, SaslSecurityLayerIntegrity)
+			if err != nil {
+				t.Fatalf("could not create security context: %s", err)
+			}
+			if _, err = ctx.unwrap(tt.token(t)); err == nil {
+				t.Errorf("unwrap accepted invalid token")
+			}
+		})
+	}
+}
+
+func TestGssapiSecurityContextWrap(t *testing.T) {
+	payload := []byte("search request")
+	encType, err := crypto.GetEtype(testKey.KeyType)
+	if err != nil {
+		t.Fatalf("could not get encryption type: %s", err)
+	}
+
+	// Integrity protected tokens must verify with the initiator's key usage and carry increasing sequence numbers
+	ctx, err := newGssapiSecurityContext(
+		testKey, types.EncryptionKey{}, types.EncryptionKey{}, 100, SaslSecurityLayerIntegrity)
+	if err != nil {
+		t.Fatalf("could not create security context: %s", err)
+	}
+	for seqNum := uint64(100); seqNum < 102; seqNum++ {
+		token, errWrap := ctx.wrap(payload)
+		if errWrap != nil {
+			t.Fatalf("wrap failed: %s", errWrap)
+		}
+		var wrapToken gssapi.WrapToken
+		if errUnmarshal := wrapToken.Unmarshal(token, false); errUnmarshal != nil {
+			t.Fatalf("could not unmarshal token: %s", errUnmarshal)
+		}
+		if ok, errVerify := wrapToken.Verify(testKey, keyusage.GSSAPI_INITIATOR_SEAL); !ok {
+			t.Fatalf("token does not verify: %s", errVerify)
+		}
+		if wrapToken.SndSeqNum != seqNum {
+			t.Errorf("token has sequence number %d, want %d", wrapToken.SndSeqNum, seqNum)
+		}
+		if !bytes.Equal(wrapToken.Payload, payload) {
+			t.Errorf("token carries %q, want %q", wrapToken.Payload, payload)
+		}
+	}
+
+	// Sealed tokens must decrypt with the initiator's key usage to the payload followed by the header
+	ctx, err = newGssapiSecurityContext(
+		testKey, types.EncryptionKey{}, testKey, 5, SaslSecurityLayerConfidentiality)
+	if err != nil {
+		t.Fatalf("could not create security context: %s", err)
+	}
+	token, err := ctx.wrap(payload)
+	if err != nil {
+		t.Fatalf("wrap failed: %s", err)
+	}
+	header := token[:gssapi.HdrLen]
+	if header[2] != wrapFlagSealed|wrapFlagAcceptorSubkey {
+		t.Errorf("token has flags 0x%02x, want 0x%02x", header[2], wrapFlagSealed|wrapFlagAcceptorSubkey)
+	}
+	plain, err := encType.DecryptMessage(testKey.KeyValue, token[gssapi.HdrLen:], keyusage.GSSAPI_INITIATOR_SEAL)
+	if err != nil {
+		t.Fatalf("could not decrypt token: %s", err)
+	}
+	if !bytes.Equal(plain, append(append([]byte{}, payload...), header...)) {
+		t.Errorf("token decrypts to %x, want payload followed by header", plain)
+	}
+}
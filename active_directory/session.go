@@ -0,0 +1,160 @@
+package active_directory
+
+import (
+	"fmt"
+
+	"github.com/go-ldap/ldap/v3"
+	"github.com/siemens/GoScans/utils"
+)
+
+// LdapSession is a bound LDAP connection together with the settings it was established with
+type LdapSession struct {
+	Conn    *ldap.Conn
+	Address string
+	Options LdapOptions
+	AuthzId string   // Authorization identity reported by the server (e.g. 'u:DOMAIN\user'), empty for anonymous binds
+	RootDse *RootDse // Naming contexts and capabilities of the server, nil if the rootDSE could not be read
+
+	foreign map[string]*LdapSession // Sessions to other domains, opened to resolve references
+}
+
+// LdapConnect establishes an LDAP session with the authentication method selected by the options and
+// determines the identity the connection is bound as
+func LdapConnect(logger utils.Logger, ldapAddress string, options LdapOptions) (*LdapSession, error) {
+
+	// Connect to LDAP with appropriate authentication method
+	conn, errConn := ldapConnectAuto(logger, ldapAddress, options)
+	if errConn != nil {
+		return nil, errConn
+	}
+
+	// Prepare session
+	session := &LdapSession{
+		Conn:    conn,
+		Address: ldapAddress,
+		Options: options,
+	}
+
+	// Read naming contexts of the server, independent of whether the bound identity can be determined
+	session.readRootDse(logger)
+
+	// Ask server for the bound identity
+	authzId, errIdentity := bindIdentity(logger, ldapAddress, conn, options)
+	if errIdentity != nil {
+		conn.Close()
+		return nil, errIdentity
+	}
+	session.AuthzId = authzId
+
+	// Return session
+	return session, nil
+}
+
+// bindIdentity asks the server for the identity the connection is bound as, using the Who Am I extended operation.
+// If authentication is required, connections are rejected if credentials were given, but the identity cannot be
+// verified or the bind silently ended up anonymous.
+func bindIdentity(logger utils.Logger, ldapAddress string, conn *ldap.Conn, options LdapOptions) (string, error) {
+
+	// Ask server for the bound identity
+	whoAmI, errWhoAmI := conn.WhoAmI(nil)
+	if errWhoAmI != nil {
+		logger.Debugf("LDAP Who Am I on '%s' failed: %s", ldapAddress, errWhoAmI)
+		if options.RequireAuthenticated && options.hasCredentials() {
+			return "", fmt.Errorf("could not verify bind identity: %w", errWhoAmI)
+		}
+		return "", nil
+	}
+
+	// Check whether the bind silently ended up anonymous
+	if whoAmI.AuthzID == "" {
+		if options.RequireAuthenticated && options.hasCredentials() {
+			return "", fmt.Errorf("bind ended up anonymous although credentials were given")
+		}
+		logger.Debugf("LDAP connection to '%s' is bound anonymously.", ldapAddress)
+	} else {
+		logger.Debugf("LDAP connection to '%s' is bound as '%s'.", ldapAddress, whoAmI.AuthzID)
+	}
+
+	// Return identity
+	return whoAmI.AuthzID, nil
+}
+
+// readRootDse reads the server's rootDSE into the session. Failures are not fatal, as base DNs can still be
+// derived from the address.
+func (s *LdapSession) readRootDse(logger utils.Logger) {
+	rootDse, errRootDse := readRootDse(logger, s)
+	if errRootDse != nil {
+		logger.Warningf("LDAP rootDSE of '%s' could not be read, deriving base DN from address: %s", s.Address, errRootDse)
+		return
+	}
+	s.RootDse = rootDse
+	logger.Debugf(
+		"LDAP server '%s' serves naming context '%s'.", rootDse.DnsHostName, rootDse.DefaultNamingContext)
+}
+
+// reconnect replaces the session's connection by a newly established one, after it got lost (e.g. by a network
+// reset). The bound identity is verified again, as the new bind might end up differently. Sessions opened to other
+// domains are kept, they reconnect on their own.
+func (s *LdapSession) reconnect(logger utils.Logger) error {
+	logger.Debugf("LDAP connection to '%s' was lost, reconnecting.", s.Address)
+	conn, errConn := ldapConnectAuto(logger, s.Address, s.Options)
+	if errConn != nil {
+		return errConn
+	}
+	authzId, errIdentity := bindIdentity(logger, s.Address, conn, s.Options)
+	if errIdentity != nil {
+		conn.Close()
+		return errIdentity
+	}
+	_ = s.Conn.Close()
+	s.Conn = conn
+	s.AuthzId = authzId
+	return nil
+}
+
+// BaseDn returns the default naming context of the server, which is the DN of the domain. It falls back to
+// interpreting the address as domain name, if the rootDSE is not available.
+func (s *LdapSession) BaseDn() string {
+	if s.RootDse != nil && s.RootDse.DefaultNamingContext != "" {
+		return s.RootDse.DefaultNamingContext
+	}
+	return fqdnToDn(ldapHost(s.Address))
+}
+
+// ConfigurationDn returns the configuration naming context of the forest
+func (s *LdapSession) ConfigurationDn() string {
+	if s.RootDse != nil && s.RootDse.ConfigurationNamingContext != "" {
+		return s.RootDse.ConfigurationNamingContext
+	}
+	return "cn=Configuration," + s.RootDomainDn()
+}
+
+// SchemaDn returns the schema naming context of the forest
+func (s *LdapSession) SchemaDn() string {
+	if s.RootDse != nil && s.RootDse.SchemaNamingContext != "" {
+		return s.RootDse.SchemaNamingContext
+	}
+	return "cn=Schema," + s.ConfigurationDn()
+}
+
+// RootDomainDn returns the naming context of the forest root domain
+func (s *LdapSession) RootDomainDn() string {
+	if s.RootDse != nil && s.RootDse.RootDomainNamingContext != "" {
+		return s.RootDse.RootDomainNamingContext
+	}
+	return s.BaseDn()
+}
+
+// Close closes the underlying LDAP connection and all sessions opened to other domains
+func (s *LdapSession) Close() {
+	for _, foreign := range s.foreign {
+		foreign.Close()
+	}
+	s.foreign = nil
+	_ = s.Conn.Close()
+}
+
+// IsAnonymous returns whether the session is bound anonymously, as far as reported by the server
+func (s *LdapSession) IsAnonymous() bool {
+	return s.AuthzId == ""
+}
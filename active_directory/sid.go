@@ -0,0 +1,74 @@
+package active_directory
+
+import (
+	"encoding/binary"
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+var (
+	sidPattern  = regexp.MustCompile(`^S-1(-\d+)+$`)
+	guidPattern = regexp.MustCompile(`^[0-9a-fA-F]{8}-[0-9a-fA-F]{4}-[0-9a-fA-F]{4}-[0-9a-fA-F]{4}-[0-9a-fA-F]{12}$`)
+)
+
+// Sid is a security identifier in its string representation (e.g. S-1-5-21-3623811015-3361044348-30300820-1013)
+type Sid string
+
+// Guid is a globally unique identifier in its canonical string representation
+// (e.g. 6f4e7e8a-1a2b-4c3d-9e8f-0123456789ab)
+type Guid string
+
+// valid returns whether the SID is well-formed and can safely be used within a <SID=...> base DN
+func (s Sid) valid() bool {
+	return sidPattern.MatchString(string(s))
+}
+
+// valid returns whether the GUID is well-formed and can safely be used within a <GUID=...> base DN
+func (g Guid) valid() bool {
+	return guidPattern.MatchString(string(g))
+}
+
+// DecodeSid converts the binary representation of a SID (e.g. of the objectSid attribute) into its string
+// representation, see MS-DTYP section 2.4.2.2
+func DecodeSid(b []byte) (Sid, error) {
+
+	// Check length of header and sub authorities
+	if len(b) < 8 {
+		return "", fmt.Errorf("SID too short: %d bytes", len(b))
+	}
+	subAuthorityCount := int(b[1])
+	if len(b) != 8+4*subAuthorityCount {
+		return "", fmt.Errorf("SID length %d does not match %d sub authorities", len(b), subAuthorityCount)
+	}
+
+	// The identifier authority is a 48 bit big endian value, the sub authorities are 32 bit little endian values
+	var authority uint64
+	for _, octet := range b[2:8] {
+		authority = authority<<8 | uint64(octet)
+	}
+	parts := []string{"S", strconv.Itoa(int(b[0])), strconv.FormatUint(authority, 10)}
+	for i := 0; i < subAuthorityCount; i++ {
+		parts = append(parts, strconv.FormatUint(uint64(binary.LittleEndian.Uint32(b[8+4*i:])), 10))
+	}
+
+	// Return SID
+	return Sid(strings.Join(parts, "-")), nil
+}
+
+// DecodeGuid converts the binary representation of a GUID (e.g. of the objectGUID attribute) into its canonical
+// string representation. The first three groups are stored little endian, the remaining bytes as they are.
+func DecodeGuid(b []byte) (Guid, error) {
+	if len(b) != 16 {
+		return "", fmt.Errorf("GUID must be 16 bytes, got %d", len(b))
+	}
+	return Guid(fmt.Sprintf(
+		"%08x-%04x-%04x-%x-%x",
+		binary.LittleEndian.Uint32(b[0:4]),
+		binary.LittleEndian.Uint16(b[4:6]),
+		binary.LittleEndian.Uint16(b[6:8]),
+		b[8:10],
+		b[10:16],
+	)), nil
+}
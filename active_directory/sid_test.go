@@ -0,0 +1,121 @@
+package active_directory
+
+import (
+	"testing"
+)
+
+func TestDecodeSid(t *testing.T) {
+	tests := []struct {
+		name  string
+		input string
+		want  Sid
+	}{
+		{
+			"domain account",
+			"010500000000000515000000c7f7fed77c7755c8945ace01f5030000",
+			"S-1-5-21-3623811015-3361044348-30300820-1013",
+		},
+		{"builtin administrators", "01020000000000052000000020020000", "S-1-5-32-544"},
+		{"everyone", "010100000000000100000000", "S-1-1-0"},
+		{"local system", "010100000000000512000000", "S-1-5-18"},
+		{"no sub authorities", "0100000000000005", "S-1-5"},
+		{"48 bit authority", "0101010203040506ffffffff", "S-1-1108152157446-4294967295"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := DecodeSid(decodeHex(t, tt.input))
+			if err != nil {
+				t.Fatalf("DecodeSid returned error: %s", err)
+			}
+			if got != tt.want {
+				t.Errorf("DecodeSid = %s, want %s", got, tt.want)
+			}
+			if !got.valid() {
+				t.Errorf("decoded SID %s is not valid", got)
+			}
+		})
+	}
+}
+
+func TestDecodeSidInvalid(t *testing.T) {
+	tests := []struct {
+		name  string
+		input string
+	}{
+		{"empty", ""},
+		{"truncated header", "01050000000000"},
+		{"missing sub authorities", "0102000000000005200000"},
+		{"excess bytes", "01010000000000010000000000"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := DecodeSid(decodeHex(t, tt.input)); err == nil {
+				t.Errorf("DecodeSid accepted invalid SID")
+			}
+		})
+	}
+}
+
+func TestDecodeGuid(t *testing.T) {
+
+	// The first three groups are stored little endian, the remaining eight bytes in order
+	got, err := DecodeGuid(decodeHex(t, "8a7e4e6f2b1a3d4c9e8f0123456789ab"))
+	if err != nil {
+		t.Fatalf("DecodeGuid returned error: %s", err)
+	}
+	if want := Guid("6f4e7e8a-1a2b-4c3d-9e8f-0123456789ab"); got != want {
+		t.Errorf("DecodeGuid = %s, want %s", got, want)
+	}
+	if !got.valid() {
+		t.Errorf("decoded GUID %s is not valid", got)
+	}
+
+	// Other lengths are rejected
+	for _, input := range []string{"", "8a7e4e6f2b1a3d4c9e8f0123456789", "8a7e4e6f2b1a3d4c9e8f0123456789abcd"} {
+		if _, err = DecodeGuid(decodeHex(t, input)); err == nil {
+			t.Errorf("DecodeGuid accepted %d bytes", len(input)/2)
+		}
+	}
+}
+
+func TestSidValid(t *testing.T) {
+	tests := []struct {
+		sid  Sid
+		want bool
+	}{
+		{"S-1-5-21-3623811015-3361044348-30300820-1013", true},
+		{"S-1-5", true},
+		{"S-1", false},
+		{"S-2-5-21", false},
+		{"s-1-5-21", false},
+		{"S-1-5-", false},
+		{"S-1-5-x", false},
+		{"S-1-5-21>,DC=example", false},
+		{"", false},
+	}
+	for _, tt := range tests {
+		if got := tt.sid.valid(); got != tt.want {
+			t.Errorf("Sid(%q).valid() = %t, want %t", tt.sid, got, tt.want)
+		}
+	}
+}
+
+func TestGuidValid(t *testing.T) {
+	tests := []struct {
+		guid Guid
+		want bool
+	}{
+		{"6f4e7e8a-1a2b-4c3d-9e8f-0123456789ab", true},
+		{"6F4E7E8A-1A2B-4C3D-9E8F-0123456789AB", true},
+		{"6f4e7e8a1a2b4c3d9e8f0123456789ab", false},
+		{"{6f4e7e8a-1a2b-4c3d-9e8f-0123456789ab}", false},
+		{"6f4e7e8a-1a2b-4c3d-9e8f-0123456789ag", false},
+		{"6f4e7e8a-1a2b-4c3d-9e8f-0123456789ab>", false},
+		{"", false},
+	}
+	for _, tt := range tests {
+		if got := tt.guid.valid(); got != tt.want {
+			t.Errorf("Guid(%q).valid() = %t, want %t", tt.guid, got, tt.want)
+		}
+	}
+}
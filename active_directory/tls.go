@@ -0,0 +1,183 @@
+package active_directory
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"net"
+	"os"
+	"strings"
+
+	"github.com/go-ldap/ldap/v3"
+	"github.com/siemens/GoScans/utils"
+	"software.sslmate.com/src/go-pkcs12"
+)
+
+// TransportMode defines how the LDAP connection is secured
+type TransportMode int
+
+const (
+	TransportPlain    TransportMode = iota // Unencrypted LDAP, usually on port 389
+	TransportStartTLS                      // LDAP upgraded to TLS via the StartTLS extended operation, usually on port 389
+	TransportLDAPS                         // LDAP over TLS right from the start, usually on port 636
+)
+
+const (
+	defaultLdapPort  = 389
+	defaultLdapsPort = 636
+)
+
+func (m TransportMode) String() string {
+	switch m {
+	case TransportPlain:
+		return "plain"
+	case TransportStartTLS:
+		return "StartTLS"
+	case TransportLDAPS:
+		return "LDAPS"
+	default:
+		return fmt.Sprintf("TransportMode(%d)", int(m))
+	}
+}
+
+// TLSOptions holds configuration for TLS secured LDAP connections
+type TLSOptions struct {
+	Mode               TransportMode
+	CAFile             string // Optional PEM bundle of CA certificates to trust, in addition to the system pool
+	ServerName         string // Optional name to verify the server certificate against, if it differs from the address
+	ClientCertFile     string // Optional PEM client certificate
+	ClientKeyFile      string // Optional PEM private key of the client certificate
+	PKCS12File         string // Optional PKCS#12 file with client certificate and private key, alternatively to PEM
+	PKCS12Password     string // Optional password of the PKCS#12 file
+	InsecureSkipVerify bool   // Disables server certificate verification. Only use for discovery in lab environments!
+}
+
+// tlsConfig builds a TLS configuration from the given options for the given server address
+func (o *TLSOptions) tlsConfig(serverAddress string) (*tls.Config, error) {
+
+	// Prepare basic config
+	conf := &tls.Config{
+		ServerName:         serverAddress,
+		InsecureSkipVerify: o.InsecureSkipVerify,
+		MinVersion:         tls.VersionTLS12,
+	}
+	if o.ServerName != "" {
+		conf.ServerName = o.ServerName
+	}
+
+	// Add custom CA bundle
+	if o.CAFile != "" {
+		pool, errPool := x509.SystemCertPool()
+		if errPool != nil || pool == nil {
+			pool = x509.NewCertPool()
+		}
+		caPem, errRead := os.ReadFile(o.CAFile)
+		if errRead != nil {
+			return nil, fmt.Errorf("could not read CA file: %w", errRead)
+		}
+		if !pool.AppendCertsFromPEM(caPem) {
+			return nil, fmt.Errorf("no certificates found in CA file '%s'", o.CAFile)
+		}
+		conf.RootCAs = pool
+	}
+
+	// Add client certificate
+	if o.hasClientCertificate() {
+		cert, errCert := loadClientCertificate(o.ClientCertFile, o.ClientKeyFile, o.PKCS12File, o.PKCS12Password)
+		if errCert != nil {
+			return nil, fmt.Errorf("could not load client certificate: %w", errCert)
+		}
+		conf.Certificates = []tls.Certificate{cert}
+	}
+
+	// Return config
+	return conf, nil
+}
+
+// hasClientCertificate returns whether a client certificate is configured
+func (o *TLSOptions) hasClientCertificate() bool {
+	return o.ClientCertFile != "" || o.ClientKeyFile != "" || o.PKCS12File != ""
+}
+
+// loadClientCertificate loads a client certificate and its private key either from PEM files or from a PKCS#12 file
+func loadClientCertificate(certFile string, keyFile string, pkcs12File string, pkcs12Password string) (tls.Certificate, error) {
+
+	// Load PEM files
+	if pkcs12File == "" {
+		return tls.LoadX509KeyPair(certFile, keyFile)
+	}
+
+	// Load PKCS#12 file, which identifies the leaf certificate belonging to the key among any CA certificates
+	data, errRead := os.ReadFile(pkcs12File)
+	if errRead != nil {
+		return tls.Certificate{}, errRead
+	}
+	key, leaf, caCerts, errDecode := pkcs12.DecodeChain(data, pkcs12Password)
+	if errDecode != nil {
+		return tls.Certificate{}, errDecode
+	}
+
+	// Return certificate, followed by its chain to be presented to the server
+	cert := tls.Certificate{
+		Certificate: [][]byte{leaf.Raw},
+		PrivateKey:  key,
+		Leaf:        leaf,
+	}
+	for _, caCert := range caCerts {
+		cert.Certificate = append(cert.Certificate, caCert.Raw)
+	}
+	return cert, nil
+}
+
+// ldapOpen opens an LDAP connection to the given address, secured according to the TLS options. The connection
+// is not yet bound.
+func ldapOpen(logger utils.Logger, ldapAddress string, options LdapOptions) (*ldap.Conn, error) {
+
+	// Prepare the ldap url by trimming any protocol specifications.
+	baseUrl := ldapHost(ldapAddress)
+
+	// Prepare the ldap options - namely the timeout.
+	dialer := &net.Dialer{Timeout: options.DialTimeout}
+	opts := []ldap.DialOpt{
+		ldap.DialWithDialer(dialer), // DialWithDialer updates net.Dialer in DialContext.
+	}
+
+	// Plain LDAP does not need any TLS configuration
+	if options.TLS == nil || options.TLS.Mode == TransportPlain {
+		return ldap.DialURL(fmt.Sprintf("ldap://%s:%d", baseUrl, options.port()), opts...)
+	}
+
+	// Prepare TLS configuration
+	tlsConf, errTls := options.TLS.tlsConfig(baseUrl)
+	if errTls != nil {
+		return nil, errTls
+	}
+
+	// Establish TLS connection right away
+	if options.TLS.Mode == TransportLDAPS {
+		opts = append(opts, ldap.DialWithTLSDialer(tlsConf, dialer))
+		return ldap.DialURL(fmt.Sprintf("ldaps://%s:%d", baseUrl, options.port()), opts...)
+	}
+
+	// Establish plain connection and upgrade it to TLS
+	conn, errDial := ldap.DialURL(fmt.Sprintf("ldap://%s:%d", baseUrl, options.port()), opts...)
+	if errDial != nil {
+		return nil, errDial
+	}
+	errStartTls := conn.StartTLS(tlsConf)
+	if errStartTls != nil {
+		conn.Close()
+		return nil, fmt.Errorf("StartTLS failed: %w", errStartTls)
+	}
+
+	// Return connection
+	return conn, nil
+}
+
+// ldapHost trims any protocol specifications from the given LDAP address
+func ldapHost(ldapAddress string) string {
+	baseUrl := strings.TrimPrefix(ldapAddress, "ldap://")
+	baseUrl = strings.TrimPrefix(baseUrl, "ldaps://")
+	baseUrl = strings.TrimPrefix(baseUrl, "ldapi://")
+	return baseUrl
+}
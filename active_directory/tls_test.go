@@ -0,0 +1,92 @@
+package active_directory
+
+import (
+	"bytes"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"math/big"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"software.sslmate.com/src/go-pkcs12"
+)
+
+// newTestCertificate creates a certificate signed by the given parent, or a self-signed one if parent is nil
+func newTestCertificate(
+	t *testing.T,
+	name string,
+	parent *x509.Certificate,
+	parentKey *ecdsa.PrivateKey,
+) (*x509.Certificate, *ecdsa.PrivateKey) {
+	t.Helper()
+	key, errKey := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if errKey != nil {
+		t.Fatalf("could not generate key: %s", errKey)
+	}
+	template := &x509.Certificate{
+		SerialNumber:          big.NewInt(time.Now().UnixNano()),
+		Subject:               pkix.Name{CommonName: name},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		BasicConstraintsValid: true,
+		IsCA:                  parent == nil,
+		KeyUsage:              x509.KeyUsageDigitalSignature | x509.KeyUsageCertSign,
+		ExtKeyUsage:           []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+	}
+	if parent == nil {
+		parent, parentKey = template, key
+	}
+	der, errCert := x509.CreateCertificate(rand.Reader, template, parent, &key.PublicKey, parentKey)
+	if errCert != nil {
+		t.Fatalf("could not create certificate: %s", errCert)
+	}
+	cert, errParse := x509.ParseCertificate(der)
+	if errParse != nil {
+		t.Fatalf("could not parse certificate: %s", errParse)
+	}
+	return cert, key
+}
+
+func TestLoadClientCertificatePkcs12(t *testing.T) {
+	ca, caKey := newTestCertificate(t, "Test CA", nil, nil)
+	leaf, leafKey := newTestCertificate(t, "client", ca, caKey)
+
+	// Encode with AES-256 and PBKDF2, as OpenSSL 3 does by default
+	pfx, errEncode := pkcs12.Modern.Encode(leafKey, leaf, []*x509.Certificate{ca}, "secret")
+	if errEncode != nil {
+		t.Fatalf("could not encode PKCS#12: %s", errEncode)
+	}
+	pfxFile := filepath.Join(t.TempDir(), "client.p12")
+	if errWrite := os.WriteFile(pfxFile, pfx, 0600); errWrite != nil {
+		t.Fatalf("could not write PKCS#12 file: %s", errWrite)
+	}
+
+	// The leaf must come first, followed by the CA certificate
+	cert, errLoad := loadClientCertificate("", "", pfxFile, "secret")
+	if errLoad != nil {
+		t.Fatalf("loadClientCertificate returned error: %s", errLoad)
+	}
+	if len(cert.Certificate) != 2 {
+		t.Fatalf("loaded %d certificates, want 2", len(cert.Certificate))
+	}
+	if !bytes.Equal(cert.Certificate[0], leaf.Raw) {
+		t.Errorf("first certificate is not the leaf")
+	}
+	if !bytes.Equal(cert.Certificate[1], ca.Raw) {
+		t.Errorf("second certificate is not the CA")
+	}
+	if key, ok := cert.PrivateKey.(*ecdsa.PrivateKey); !ok || !key.Equal(leafKey) {
+		t.Errorf("private key does not belong to the leaf")
+	}
+
+	// A wrong password must be rejected
+	_, errLoad = loadClientCertificate("", "", pfxFile, "wrong")
+	if errLoad == nil {
+		t.Errorf("loadClientCertificate accepted wrong password")
+	}
+}
@@ -0,0 +1,98 @@
+package active_directory
+
+import (
+	"fmt"
+	"strings"
+)
+
+// UserAccountControl is the bitmask of the userAccountControl and msDS-User-Account-Control-Computed attributes
+type UserAccountControl uint32
+
+// User account control flags, see MS-ADTS section 2.2.16
+const (
+	UacScript                     UserAccountControl = 0x00000001
+	UacAccountDisable             UserAccountControl = 0x00000002
+	UacHomedirRequired            UserAccountControl = 0x00000008
+	UacLockout                    UserAccountControl = 0x00000010
+	UacPasswdNotReqd              UserAccountControl = 0x00000020
+	UacPasswdCantChange           UserAccountControl = 0x00000040
+	UacEncryptedTextPwdAllowed    UserAccountControl = 0x00000080
+	UacTempDuplicateAccount       UserAccountControl = 0x00000100
+	UacNormalAccount              UserAccountControl = 0x00000200
+	UacInterdomainTrustAccount    UserAccountControl = 0x00000800
+	UacWorkstationTrustAccount    UserAccountControl = 0x00001000
+	UacServerTrustAccount         UserAccountControl = 0x00002000
+	UacDontExpirePassword         UserAccountControl = 0x00010000
+	UacMnsLogonAccount            UserAccountControl = 0x00020000
+	UacSmartcardRequired          UserAccountControl = 0x00040000
+	UacTrustedForDelegation       UserAccountControl = 0x00080000
+	UacNotDelegated               UserAccountControl = 0x00100000
+	UacUseDesKeyOnly              UserAccountControl = 0x00200000
+	UacDontReqPreauth             UserAccountControl = 0x00400000
+	UacPasswordExpired            UserAccountControl = 0x00800000
+	UacTrustedToAuthForDelegation UserAccountControl = 0x01000000
+	UacNoAuthDataRequired         UserAccountControl = 0x02000000
+	UacPartialSecretsAccount      UserAccountControl = 0x04000000
+	UacUseAesKeys                 UserAccountControl = 0x08000000
+	uacKnownFlags                                    = UacScript | UacAccountDisable | UacHomedirRequired |
+		UacLockout | UacPasswdNotReqd | UacPasswdCantChange | UacEncryptedTextPwdAllowed | UacTempDuplicateAccount |
+		UacNormalAccount | UacInterdomainTrustAccount | UacWorkstationTrustAccount | UacServerTrustAccount |
+		UacDontExpirePassword | UacMnsLogonAccount | UacSmartcardRequired | UacTrustedForDelegation |
+		UacNotDelegated | UacUseDesKeyOnly | UacDontReqPreauth | UacPasswordExpired |
+		UacTrustedToAuthForDelegation | UacNoAuthDataRequired | UacPartialSecretsAccount | UacUseAesKeys
+)
+
+// uacNames holds the flag names as documented by Microsoft, in ascending order of their values
+var uacNames = []struct {
+	flag UserAccountControl
+	name string
+}{
+	{UacScript, "SCRIPT"},
+	{UacAccountDisable, "ACCOUNTDISABLE"},
+	{UacHomedirRequired, "HOMEDIR_REQUIRED"},
+	{UacLockout, "LOCKOUT"},
+	{UacPasswdNotReqd, "PASSWD_NOTREQD"},
+	{UacPasswdCantChange, "PASSWD_CANT_CHANGE"},
+	{UacEncryptedTextPwdAllowed, "ENCRYPTED_TEXT_PWD_ALLOWED"},
+	{UacTempDuplicateAccount, "TEMP_DUPLICATE_ACCOUNT"},
+	{UacNormalAccount, "NORMAL_ACCOUNT"},
+	{UacInterdomainTrustAccount, "INTERDOMAIN_TRUST_ACCOUNT"},
+	{UacWorkstationTrustAccount, "WORKSTATION_TRUST_ACCOUNT"},
+	{UacServerTrustAccount, "SERVER_TRUST_ACCOUNT"},
+	{UacDontExpirePassword, "DONT_EXPIRE_PASSWORD"},
+	{UacMnsLogonAccount, "MNS_LOGON_ACCOUNT"},
+	{UacSmartcardRequired, "SMARTCARD_REQUIRED"},
+	{UacTrustedForDelegation, "TRUSTED_FOR_DELEGATION"},
+	{UacNotDelegated, "NOT_DELEGATED"},
+	{UacUseDesKeyOnly, "USE_DES_KEY_ONLY"},
+	{UacDontReqPreauth, "DONT_REQ_PREAUTH"},
+	{UacPasswordExpired, "PASSWORD_EXPIRED"},
+	{UacTrustedToAuthForDelegation, "TRUSTED_TO_AUTH_FOR_DELEGATION"},
+	{UacNoAuthDataRequired, "NO_AUTH_DATA_REQUIRED"},
+	{UacPartialSecretsAccount, "PARTIAL_SECRETS_ACCOUNT"},
+	{UacUseAesKeys, "USE_AES_KEYS"},
+}
+
+// Has returns whether all bits of the given flag(s) are set
+func (u UserAccountControl) Has(flag UserAccountControl) bool {
+	return u&flag == flag
+}
+
+// Flags returns the names of all set flags. Unknown bits are returned as hex value.
+func (u UserAccountControl) Flags() []string {
+	var names []string
+	for _, entry := range uacNames {
+		if u.Has(entry.flag) {
+			names = append(names, entry.name)
+		}
+	}
+	if unknown := u &^ uacKnownFlags; unknown != 0 {
+		names = append(names, fmt.Sprintf("0x%08x", uint32(unknown)))
+	}
+	return names
+}
+
+// String returns the names of all set flags separated by '|' (e.g. ACCOUNTDISABLE|WORKSTATION_TRUST_ACCOUNT)
+func (u UserAccountControl) String() string {
+	return strings.Join(u.Flags(), "|")
+}
@@ -0,0 +1,120 @@
+package active_directory
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/go-ldap/ldap/v3"
+	"github.com/siemens/GoScans/utils"
+)
+
+// userFilter matches user accounts, excluding computers and contacts
+const userFilter = "(&(objectCategory=person)(objectClass=user))"
+
+// User describes a user account
+type User struct {
+	DistinguishedName    string             `ldap:"distinguishedName"`
+	ObjectSid            Sid                `ldap:"objectSid"`
+	ObjectGuid           Guid               `ldap:"objectGUID"`
+	SamAccountName       string             `ldap:"sAMAccountName"`
+	UserPrincipalName    string             `ldap:"userPrincipalName"`
+	DisplayName          string             `ldap:"displayName"`
+	Mail                 string             `ldap:"mail"`
+	Department           string             `ldap:"department"`
+	Title                string             `ldap:"title"`
+	EmployeeId           string             // Attribute as per AttributeProfile
+	Manager              string             `ldap:"manager"`
+	MemberOf             []string           `ldap:"memberOf"` // Direct group memberships, without the primary group
+	UserAccountControl   UserAccountControl `ldap:"userAccountControl"`
+	UserAccountComputed  UserAccountControl `ldap:"msDS-User-Account-Control-Computed"` // Flags computed by the DC, e.g. LOCKOUT and PASSWORD_EXPIRED
+	Created              time.Time          `ldap:"whenCreated"`
+	LastPassword         time.Time          `ldap:"pwdLastSet"`         // Zero if the password must be changed at next logon
+	LastLogonTimestamp   time.Time          `ldap:"lastLogonTimestamp"` // Replicated, but only updated if older than 9-14 days
+	AccountExpires       time.Time          `ldap:"accountExpires"`     // Zero if the account never expires
+	AdminCount           int                `ldap:"adminCount"`         // 1 if the account is or was member of a protected group
+	ServicePrincipalName []string           `ldap:"servicePrincipalName"`
+}
+
+// newUser decodes an LDAP entry into a user record. Values that cannot be decoded are logged and left empty.
+func newUser(logger utils.Logger, session *LdapSession, entry *ldap.Entry, profile *AttributeProfile) *User {
+	user := &User{}
+	errDecode := unmarshalEntry(entry, user, profile.userFieldAttributes(), session.rangeReader(logger))
+	if errDecode != nil {
+		logger.Errorf("Could not decode LDAP entry of user '%s': %s", entry.DN, errDecode)
+	}
+	return user
+}
+
+// LookupUser searches the user with the given sAMAccountName or userPrincipalName within the session's domain.
+// Nil is returned if no such user exists.
+func LookupUser(logger utils.Logger, session *LdapSession, name string) (*User, error) {
+
+	// Prepare search
+	profile := profileOrDefault(session.Options.Attributes)
+	escaped := ldap.EscapeFilter(name)
+	logger.Debugf("LDAP searching for user '%s' in '%s'.", name, session.Address)
+	userSearch := ldap.NewSearchRequest(
+		session.BaseDn(), // The base dn to search
+		ldap.ScopeWholeSubtree,
+		ldap.NeverDerefAliases,
+		0,
+		0,
+		false,
+		fmt.Sprintf("(&%s(|(sAMAccountName=%s)(userPrincipalName=%s)))", userFilter, escaped, escaped), // The filter to apply
+		profile.userAttributes(),
+		nil,
+	)
+
+	// Execute search
+	userResult, errSearch := session.search(logger, userSearch)
+	if errSearch != nil {
+		return nil, errSearch
+	}
+
+	// Check for result
+	if len(userResult.Entries) == 0 {
+		logger.Debugf("LDAP search for user '%s' in '%s' did not return result.", name, session.Address)
+		return nil, nil
+	} else if len(userResult.Entries) > 1 {
+		return nil, fmt.Errorf("search for user '%s' returned ambiguous results", name)
+	}
+
+	// Return decoded user
+	return newUser(logger, session, userResult.Entries[0], profile), nil
+}
+
+// EnumerateUsers lists all users of the session's domain matching the given additional filter (e.g.
+// '(adminCount=1)'), or all users if the filter is empty. Results are retrieved in pages, so they are not
+// limited by the server's size limit.
+func EnumerateUsers(logger utils.Logger, session *LdapSession, filter string) ([]*User, error) {
+
+	// Prepare search
+	profile := profileOrDefault(session.Options.Attributes)
+	logger.Debugf("LDAP enumerating users in '%s'.", session.Address)
+	userSearch := ldap.NewSearchRequest(
+		session.BaseDn(), // The base dn to search
+		ldap.ScopeWholeSubtree,
+		ldap.NeverDerefAliases,
+		0,
+		0,
+		false,
+		fmt.Sprintf("(&%s%s)", userFilter, filter), // The filter to apply
+		profile.userAttributes(),
+		nil,
+	)
+
+	// Execute search
+	userResult, errSearch := session.searchPaged(logger, userSearch)
+	if errSearch != nil {
+		return nil, errSearch
+	}
+
+	// Decode users
+	users := make([]*User, 0, len(userResult.Entries))
+	for _, entry := range userResult.Entries {
+		users = append(users, newUser(logger, session, entry, profile))
+	}
+
+	// Return users
+	return users, nil
+}
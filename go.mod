@@ -8,6 +8,7 @@ require (
 	github.com/jcmturner/gokrb5/v8 v8.4.4
 	github.com/mattn/go-adodb v0.0.2-0.20200211113401-5e535a33399b
 	github.com/siemens/GoScans v1.0.2
+	software.sslmate.com/src/go-pkcs12 v0.7.3
 )
 
 require (
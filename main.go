@@ -124,11 +124,15 @@ func main() {
 		logger,
 		searchCnLdap,
 		ldapHost,
-		ldapPort,
-		ldapUser,
-		ldapPassword,
-		60*time.Second,
-		gssapiOptions,
+		active_directory.LdapOptions{
+			Port:        ldapPort,
+			User:        ldapUser,
+			Password:    ldapPassword,
+			DialTimeout: 60 * time.Second,
+			GSSAPI:      gssapiOptions,
+			TLS:         nil, // e.g. &active_directory.TLSOptions{Mode: active_directory.TransportStartTLS}
+			Retry:       active_directory.DefaultRetryOptions(),
+		},
 	)
 
 	if adResultLdap != nil && adResultLdap.Name != "" {